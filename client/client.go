@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/coreos/etcd/clientv3"
 	"github.com/shirou/gopsutil/cpu"
@@ -13,18 +14,23 @@ import (
 	"os/signal"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 )
 
 const (
-	GB           uint64 = 1024 * 1024 * 1024
-	ETCD_KEY_PRE        = "/iCloud/host_info/"
+	GB                    uint64 = 1024 * 1024 * 1024
+	ETCD_KEY_PRE                 = "/iCloud/host_info/"
+	HOST_LEASE_TTL               = 10 // seconds, key of host is deleted by etcd if lease is not kept alive in it
+	HOST_REFRESH_INTERVAL        = time.Second * 3
 )
 
 var (
 	Conf     *ClientConf
 	HostInfo *Host
 	etcdCli  *clientv3.Client
+	leaseId  clientv3.LeaseID // it is set by register goroutine and read by main goroutine when client exits
+	leaseMu  sync.Mutex
 )
 
 type Host struct {
@@ -40,6 +46,7 @@ type Host struct {
 	FreeDisk  int    `json:"freeDisk"`
 	Heartbeat int64  `json:"heartbeat"` // current  timestamp
 	GrpcPort  string `json:"grpcPort"`	// grpc server listen on
+	Labels    map[string]string `json:"labels"`
 }
 
 func init() {
@@ -108,6 +115,9 @@ func (h *Host) Refresh() {
 	}
 	h.Heartbeat = time.Now().Unix()
 	h.GrpcPort = Conf.RpcPort
+	for _, label := range Conf.Labels {
+		h.Labels[label.Key] = label.Value
+	}
 }
 
 func (h *Host) sumDisk(partitions *[]disk.PartitionStat) {
@@ -141,13 +151,71 @@ func (h *Host) empty() {
 	h.TotalDisk = 0
 	h.FreeDisk = 0
 	h.GrpcPort = ""
+	h.Labels = make(map[string]string)
 }
 
-func hostRegister() {
+// register host to etcd with lease, host info is refreshed every HOST_REFRESH_INTERVAL,
+// key of host is deleted by etcd when client is dead and lease is not kept alive
+func hostRegister(ctx context.Context) (err error) {
 	var (
-		err error
-		h   []byte
-		m   string = "client.HostRegister()"
+		m           = "client.hostRegister()"
+		grantRsp    *clientv3.LeaseGrantResponse
+		keepAliveCh <-chan *clientv3.LeaseKeepAliveResponse
+		keepAlive   *clientv3.LeaseKeepAliveResponse
+		ticker      = time.NewTicker(HOST_REFRESH_INTERVAL)
+		lease       = clientv3.NoLease
+	)
+	defer ticker.Stop()
+
+	// keep alive of lease is stopped when this attempt returns, and lease is revoked if it fails,
+	// so leases of failed attempts are not kept alive while client is retrying
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer func() {
+		if err != nil && lease != clientv3.NoLease {
+			leaseRevoke(lease)
+			leaseSet(clientv3.NoLease)
+		}
+	}()
+
+	if grantRsp, err = etcdCli.Grant(ctx, HOST_LEASE_TTL); err != nil {
+		Logger.Errorf("%s error, grant lease from etcd error: %v", m, err)
+		return
+	}
+	lease = grantRsp.ID
+	leaseSet(lease)
+
+	if err = hostPut(ctx, lease); err != nil {
+		return
+	}
+
+	if keepAliveCh, err = etcdCli.KeepAlive(ctx, lease); err != nil {
+		Logger.Errorf("%s error, keep lease %x alive error: %v", m, lease, err)
+		return
+	}
+	Logger.Infof("host %s registered to etcd with lease %x", HostInfo.Ip, lease)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case keepAlive = <-keepAliveCh:
+			if keepAlive == nil {
+				Logger.Errorf("%s error, lease %x is expired or keep alive channel is closed", m, lease)
+				return errors.New("lease keep alive failed")
+			}
+		case <-ticker.C:
+			if err = hostPut(ctx, lease); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func hostPut(ctx context.Context, lease clientv3.LeaseID) (err error) {
+	var (
+		h []byte
+		m = "client.hostPut()"
 	)
 
 	HostInfo.Refresh()
@@ -157,9 +225,42 @@ func hostRegister() {
 		return
 	}
 
-	if _, err = etcdCli.Put(context.TODO(), ETCD_KEY_PRE+HostInfo.Ip, string(h)); err != nil {
+	if _, err = etcdCli.Put(ctx, ETCD_KEY_PRE+HostInfo.Ip, string(h), clientv3.WithLease(lease)); err != nil {
 		Logger.Errorf("%s error, %s put to etcd error: %v", m, HostInfo.Ip, err)
 	}
+	return
+}
+
+func leaseSet(id clientv3.LeaseID) {
+	leaseMu.Lock()
+	defer leaseMu.Unlock()
+	leaseId = id
+}
+
+func leaseGet() clientv3.LeaseID {
+	leaseMu.Lock()
+	defer leaseMu.Unlock()
+	return leaseId
+}
+
+// revoke lease when client exit, to make host offline immediately
+func hostUnregister() {
+	if lease := leaseGet(); lease != clientv3.NoLease {
+		leaseRevoke(lease)
+	}
+}
+
+func leaseRevoke(lease clientv3.LeaseID) {
+	var (
+		m = "client.leaseRevoke()"
+	)
+
+	revokeCtx, revokeCancel := context.WithTimeout(context.TODO(), time.Second*2)
+	defer revokeCancel()
+
+	if _, err := etcdCli.Revoke(revokeCtx, lease); err != nil {
+		Logger.Errorf("%s error, revoke lease %x error: %v", m, lease, err)
+	}
 }
 
 func main() {
@@ -173,10 +274,19 @@ func main() {
 
 	HostInfo = new(Host)
 
+	registerCtx, registerCancel := context.WithCancel(context.TODO())
+	defer func() {
+		registerCancel()
+		hostUnregister()
+	}()
+
 	go func() {
 		for {
-			hostRegister()
-			time.Sleep(time.Second * 3)
+			if err := hostRegister(registerCtx); err == nil || registerCtx.Err() != nil {
+				return
+			}
+			// register again with new lease
+			time.Sleep(HOST_REFRESH_INTERVAL)
 		}
 	}()
 
@@ -184,7 +294,7 @@ func main() {
 		RunRpcServer()
 	}()
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	s := <-c
 	fmt.Println(s)
@@ -9,17 +9,33 @@ import (
 const CONF_NAME = "./clientConf.xml"
 
 type ClientConf struct {
-	ExportIp   string   `xml:"exportIp"`
-	ApiPort    string   `xml:"apiPort"` // docker remote api port
-	Etcd       []string `xml:"etcd"`    // etcd ip:port
-	Mongo      string   `xml:"mongo"`   // mondoDB
-	Log        string   `xml:"log"`
-	Level      string   `xml:"level"`
-	MaxSize    int      `xml:"maxSize"`    // max size of log (MB)
-	MaxBackups int      `xml:"maxBackups"` // max number of old log
-	MaxAge     int      `xml:"maxAge"`     // ax days of old log retained
-	Compress   bool     `xml:"compress"`   // compress or not
-	RpcPort    string   `xml:"rpcPort"`
+	ExportIp    string      `xml:"exportIp"`
+	ApiPort     string      `xml:"apiPort"` // docker remote api port
+	Etcd        []string    `xml:"etcd"`    // etcd ip:port
+	Mongo       string      `xml:"mongo"`   // mondoDB
+	Log         string      `xml:"log"`
+	Level       string      `xml:"level"`
+	MaxSize     int         `xml:"maxSize"`    // max size of log (MB)
+	MaxBackups  int         `xml:"maxBackups"` // max number of old log
+	MaxAge      int         `xml:"maxAge"`     // ax days of old log retained
+	Compress    bool        `xml:"compress"`   // compress or not
+	RpcPort     string      `xml:"rpcPort"`
+	Labels      []HostLabel `xml:"label"`       // labels of host, used by server to select hosts
+	RpcTls      *RpcTlsConf `xml:"rpcTls"`      // mutual tls of rpc server
+	RpcInsecure bool        `xml:"rpcInsecure"` // rpc server runs without tls and accepts any caller if rpcTls is not set
+}
+
+// certificates in PEM, server of iCloud must send certificate signed by CA
+type RpcTlsConf struct {
+	CA           string   `xml:"ca"`
+	Cert         string   `xml:"cert"`
+	Key          string   `xml:"key"`
+	AllowedNames []string `xml:"allowedName"` // common name or dns name in certificate of caller, any name signed by CA is allowed if it is empty
+}
+
+type HostLabel struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
 }
 
 func (conf *ClientConf) newConf() (err error) {
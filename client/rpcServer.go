@@ -3,10 +3,17 @@ package main
 import (
 	"client/rpcServer"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
 	"net"
 	"os"
 	"path"
@@ -37,6 +44,10 @@ func createContainerEntryPointScriptHandler(req *rpcServer.CreateScriptRequest)
 		content         = make([]string, 0)
 	)
 
+	if !path.IsAbs(req.Pwd) {
+		return errors.New("working directory of container must be absolute path")
+	}
+
 	if _, err = os.Stat(script); err != nil {
 		if os.IsExist(err) {
 			removeScriptErr = os.Remove(script)
@@ -63,13 +74,102 @@ func createContainerEntryPointScriptHandler(req *rpcServer.CreateScriptRequest)
 	return nil
 }
 
+// tls of rpc server, certificate of caller is required and verified by CA
+func rpcServerTlsConfig(tlsConf *RpcTlsConf) (config *tls.Config, err error) {
+	var (
+		caPem   []byte
+		certs   = x509.NewCertPool()
+		keyPair tls.Certificate
+	)
+
+	if caPem, err = ioutil.ReadFile(tlsConf.CA); err != nil {
+		return nil, fmt.Errorf("read CA file %s error: %v", tlsConf.CA, err)
+	}
+	if !certs.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("no certificate in CA file %s", tlsConf.CA)
+	}
+
+	if keyPair, err = tls.LoadX509KeyPair(tlsConf.Cert, tlsConf.Key); err != nil {
+		return nil, fmt.Errorf("load certificate %s and key %s error: %v", tlsConf.Cert, tlsConf.Key, err)
+	}
+
+	return &tls.Config{
+		ClientCAs:    certs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		Certificates: []tls.Certificate{keyPair},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// caller must be verified by tls, and its name must be allowed
+func rpcCallerAuth(ctx context.Context) error {
+	var (
+		p       *peer.Peer
+		ok      bool
+		tlsInfo credentials.TLSInfo
+	)
+
+	if Conf.RpcTls == nil && Conf.RpcInsecure {
+		return nil
+	}
+
+	if p, ok = peer.FromContext(ctx); !ok {
+		return status.Error(codes.Unauthenticated, "caller is unknown")
+	}
+	if tlsInfo, ok = p.AuthInfo.(credentials.TLSInfo); !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return status.Error(codes.Unauthenticated, "certificate of caller is not verified")
+	}
+
+	if len(Conf.RpcTls.AllowedNames) == 0 {
+		return nil
+	}
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	for _, name := range Conf.RpcTls.AllowedNames {
+		if cert.Subject.CommonName == name || cert.VerifyHostname(name) == nil {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "caller %s is not allowed", cert.Subject.CommonName)
+}
+
+func rpcAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := rpcCallerAuth(ctx); err != nil {
+		if p, ok := peer.FromContext(ctx); ok {
+			Logger.Errorf("client.rpcAuthInterceptor() error, reject call %s from %s: %v", info.FullMethod, p.Addr, err)
+		}
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
 func RunRpcServer() {
+	var (
+		m         = "client.RunRpcServer()"
+		opts      = []grpc.ServerOption{grpc.UnaryInterceptor(rpcAuthInterceptor)}
+		tlsConfig *tls.Config
+		err       error
+	)
+
+	switch {
+	case Conf.RpcTls != nil:
+		if tlsConfig, err = rpcServerTlsConfig(Conf.RpcTls); err != nil {
+			Logger.Errorf("%s error, load tls of rpc server error: %v", m, err)
+			return
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	case Conf.RpcInsecure:
+		Logger.Info("rpc server runs without tls, any caller is accepted")
+	default:
+		Logger.Errorf("%s error, rpcTls is not set in %s, set rpcInsecure to true to run rpc server without tls", m, CONF_NAME)
+		return
+	}
+
 	lis, err := net.Listen("tcp", ":" + Conf.RpcPort)
 	if err != nil {
 		fmt.Println("rpcServer listen to port 19876 error:", err)
 		return
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(opts...)
 	rpcServer.RegisterCreateContainerEntryPointScriptServer(s, &RpcServer{})
 	reflection.Register(s)
 
@@ -0,0 +1,553 @@
+package apps
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"iCloud/commons"
+	"iCloud/conf"
+	"iCloud/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	AUTH_TYPE_JWT       = "jwt"
+	AUTH_TYPE_API_TOKEN = "apiToken"
+
+	AUTH_CALLER_KEY     = "caller" // key of caller in gin context
+	AUTH_COOKIE_NAME    = "iCloudToken"
+	AUTH_QUERY_NAME     = "access_token" // token in url, for EventSource and websocket which can not set header
+	AUTH_JWT_ISSUER     = "iCloud"
+	API_TOKEN_PREFIX    = "ict_"
+	USER_MIN_PASSWORD   = 8
+	AUTH_DEFAULT_EXPIRE = 24 // hours
+)
+
+var (
+	Users     = NewMemoryUserRepository()
+	jwtSecret []byte
+)
+
+type User struct {
+	Id           string   `json:"id" bson:"_id"`
+	Username     string   `json:"username" bson:"username"`
+	PasswordHash string   `json:"-" bson:"passwordHash"`
+	Role         string   `json:"role" bson:"role"`
+	Projects     []string `json:"projects" bson:"projects"`
+	CreateTime   int64    `json:"createTime" bson:"createTime"`
+}
+
+// personal token used by scripts, only sha256 of token is stored and token is shown once when it is created
+type ApiToken struct {
+	Id         string `json:"id" bson:"_id"`
+	Name       string `json:"name" bson:"name"`
+	Username   string `json:"username" bson:"username"`
+	Hash       string `json:"-" bson:"hash"`
+	CreateTime int64  `json:"createTime" bson:"createTime"`
+	ExpireTime int64  `json:"expireTime" bson:"expireTime"` // 0 is never expired
+}
+
+// identity of request, it is set in gin context by AuthRequired
+type Caller struct {
+	User     *User  `json:"user"`
+	AuthType string `json:"authType"`
+	TokenId  string `json:"tokenId,omitempty"` // id of api token
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type UserCreateRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Role     string   `json:"role"` // default is viewer
+	Projects []string `json:"projects"`
+}
+
+type PasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+type ApiTokenRequest struct {
+	Name       string `json:"name"`
+	ExpireDays int    `json:"expireDays"` // 0 is never expired
+}
+
+// key of login token and the first user
+func AuthInit() {
+	var (
+		m        = "apps.auth.AuthInit()"
+		admin    = conf.Iconf.Auth.Admin
+		count    int64
+		err      error
+		hash     string
+		user     *User
+		password string
+		authCtx  context.Context
+		cancel   context.CancelFunc
+	)
+
+	if conf.Iconf.Auth.JwtSecret != "" {
+		jwtSecret = []byte(conf.Iconf.Auth.JwtSecret)
+	} else {
+		jwtSecret = make([]byte, 32)
+		if _, err = rand.Read(jwtSecret); err != nil {
+			panic("generate key of login token error: " + err.Error())
+		}
+		log.Logger.Info("jwtSecret is not set, login token is invalid after restart")
+	}
+
+	authCtx, cancel = context.WithTimeout(context.TODO(), commons.MONGO_TIMEOUT)
+	defer cancel()
+	if count, err = Users.Count(authCtx); err != nil {
+		log.Logger.Errorf("%s error, count users error: %v", m, err)
+		return
+	}
+	if admin.Username == "" {
+		return
+	}
+
+	// admin created before roles are introduced has no role
+	if count > 0 {
+		if user, err = Users.Get(authCtx, admin.Username); err == nil && user.Role == "" {
+			user.Role = ROLE_ADMIN
+			if err = Users.Update(authCtx, user); err != nil {
+				log.Logger.Errorf("%s error, set role of admin %s error: %v", m, admin.Username, err)
+			}
+		}
+		return
+	}
+
+	// there is no default password, random one is printed once if it is not set
+	password = admin.Password
+	if password == "" {
+		if password, err = randomPassword(); err != nil {
+			panic("generate password of admin error: " + err.Error())
+		}
+	}
+	if hash, err = passwordHash(password); err != nil {
+		log.Logger.Errorf("%s error, create admin %s error: %v", m, admin.Username, err)
+		return
+	}
+	if err = Users.Insert(authCtx, newUser(admin.Username, hash, ROLE_ADMIN, nil)); err != nil {
+		log.Logger.Errorf("%s error, create admin %s error: %v", m, admin.Username, err)
+		return
+	}
+	log.Logger.Infof("there is no user, admin %s is created", admin.Username)
+	if admin.Password == "" {
+		fmt.Printf("admin %s is created with password %s, change it after first login\n", admin.Username, password)
+	}
+}
+
+func newUser(username, passwordHash, role string, projects []string) *User {
+	if projects == nil {
+		projects = make([]string, 0)
+	}
+	return &User{
+		Id:           primitive.NewObjectID().Hex(),
+		Username:     username,
+		PasswordHash: passwordHash,
+		Role:         role,
+		Projects:     projects,
+		CreateTime:   time.Now().Unix(),
+	}
+}
+
+func passwordHash(password string) (string, error) {
+	if len(password) < USER_MIN_PASSWORD {
+		return "", errors.New("password is too short")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func jwtIssue(username string) (token string, expireTime int64, err error) {
+	var (
+		hours = conf.Iconf.Auth.TokenExpire
+		now   = time.Now()
+	)
+	if hours <= 0 {
+		hours = AUTH_DEFAULT_EXPIRE
+	}
+	expireTime = now.Add(time.Hour * time.Duration(hours)).Unix()
+
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+		Subject:   username,
+		Issuer:    AUTH_JWT_ISSUER,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expireTime,
+	}).SignedString(jwtSecret)
+	return
+}
+
+// username in login token
+func jwtParse(token string) (username string, err error) {
+	var (
+		claims = new(jwt.StandardClaims)
+	)
+
+	if _, err = jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	}); err != nil {
+		return
+	}
+	if claims.Issuer != AUTH_JWT_ISSUER || claims.Subject == "" {
+		return "", errors.New("token is not issued by iCloud")
+	}
+	return claims.Subject, nil
+}
+
+// token in header "Authorization: Bearer <token>", cookie, or url
+func requestToken(ctx *gin.Context) string {
+	if auth := ctx.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if cookie, err := ctx.Cookie(AUTH_COOKIE_NAME); err == nil && cookie != "" {
+		return cookie
+	}
+	return ctx.Query(AUTH_QUERY_NAME)
+}
+
+func callerAuth(ctx context.Context, token string) (caller *Caller, err error) {
+	var (
+		username string
+		apiToken *ApiToken
+		user     *User
+	)
+
+	if strings.HasPrefix(token, API_TOKEN_PREFIX) {
+		if apiToken, err = Users.GetTokenByHash(ctx, tokenHash(token)); err != nil {
+			return
+		}
+		if apiToken.ExpireTime > 0 && apiToken.ExpireTime < time.Now().Unix() {
+			return nil, errors.New("api token is expired")
+		}
+		caller = &Caller{AuthType: AUTH_TYPE_API_TOKEN, TokenId: apiToken.Id}
+		username = apiToken.Username
+	} else {
+		if username, err = jwtParse(token); err != nil {
+			return
+		}
+		caller = &Caller{AuthType: AUTH_TYPE_JWT}
+	}
+
+	// user may be deleted after token is issued
+	if user, err = Users.Get(ctx, username); err != nil {
+		return nil, err
+	}
+	caller.User = user
+	return
+}
+
+// middleware which rejects request without valid token, and sets caller of request in gin context
+func AuthRequired() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var (
+			m      = "apps.auth.AuthRequired()"
+			token  = requestToken(ctx)
+			caller *Caller
+			err    error
+		)
+
+		if token == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ErrorCode": 1, "Data": "login is required"})
+			return
+		}
+
+		authCtx, cancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+		defer cancel()
+		if caller, err = callerAuth(authCtx, token); err != nil {
+			if err != ErrUserNotFound && err != ErrApiTokenNotFound {
+				log.Logger.Errorf("%s error, authenticate request from %s error: %v", m, ctx.ClientIP(), err)
+			}
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ErrorCode": 1, "Data": "token is invalid or expired"})
+			return
+		}
+
+		ctx.Set(AUTH_CALLER_KEY, caller)
+		ctx.Next()
+	}
+}
+
+// caller of request, false if request is not authenticated
+func CallerOf(ctx *gin.Context) (*Caller, bool) {
+	if v, exist := ctx.Get(AUTH_CALLER_KEY); exist {
+		caller, ok := v.(*Caller)
+		return caller, ok
+	}
+	return nil, false
+}
+
+// login with username and password, token is returned and set in cookie
+func UserLogin(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.auth.UserLogin()"
+		req        = new(LoginRequest)
+		user       *User
+		token      string
+		expireTime int64
+		err        error
+	)
+
+	if err = ctx.BindJSON(req); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+
+	if user, err = Users.Get(ctx, req.Username); err != nil {
+		if err != ErrUserNotFound {
+			log.Logger.Errorf("%s error, get user %s error: %v", m, req.Username, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "username or password is wrong"
+		goto RESPONSE
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		log.Logger.Infof("login of %s from %s failed, password is wrong", req.Username, ctx.ClientIP())
+		rsp["ErrorCode"], rsp["Data"] = 1, "username or password is wrong"
+		goto RESPONSE
+	}
+
+	if token, expireTime, err = jwtIssue(user.Username); err != nil {
+		log.Logger.Errorf("%s error, issue token to %s error: %v", m, user.Username, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "issue token error"
+		goto RESPONSE
+	}
+
+	authCookieSet(ctx, token, int(expireTime-time.Now().Unix()))
+	rsp["ErrorCode"], rsp["Data"] = 0, gin.H{"token": token, "expireTime": expireTime, "user": user}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// cookie is not sent in cross site requests, it is sent only in https if cookieSecure is set
+func authCookieSet(ctx *gin.Context, token string, maxAge int) {
+	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(AUTH_COOKIE_NAME, token, maxAge, "/", "", conf.Iconf.Auth.CookieSecure, true)
+}
+
+func UserLogout(ctx *gin.Context) {
+	authCookieSet(ctx, "", -1)
+	ctx.JSON(http.StatusOK, gin.H{"ErrorCode": 0, "Data": ""})
+}
+
+// caller of request
+func UserMe(ctx *gin.Context) {
+	caller, _ := CallerOf(ctx)
+	ctx.JSON(http.StatusOK, gin.H{"ErrorCode": 0, "Data": caller})
+}
+
+func UserPasswordChange(ctx *gin.Context) {
+	var (
+		rsp       = make(gin.H)
+		m         = "apps.auth.UserPasswordChange()"
+		req       = new(PasswordRequest)
+		caller, _ = CallerOf(ctx)
+		user      = *caller.User
+		err       error
+	)
+
+	if err = ctx.BindJSON(req); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.OldPassword)); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "old password is wrong"
+		goto RESPONSE
+	}
+
+	if user.PasswordHash, err = passwordHash(req.NewPassword); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = Users.Update(ctx, &user); err != nil {
+		log.Logger.Errorf("%s error, update password of %s error: %v", m, user.Username, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "update password error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, ""
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func UserCreate(ctx *gin.Context) {
+	var (
+		rsp  = make(gin.H)
+		m    = "apps.auth.UserCreate()"
+		req  = new(UserCreateRequest)
+		user *User
+		hash string
+		err  error
+	)
+
+	if err = ctx.BindJSON(req); err != nil || req.Username == "" || strings.HasPrefix(req.Username, API_TOKEN_PREFIX) {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, username is required"
+		goto RESPONSE
+	}
+
+	if req.Role == "" {
+		req.Role = ROLE_VIEWER
+	}
+	if !roleValid(req.Role) {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, role is one of admin, member and viewer"
+		goto RESPONSE
+	}
+
+	if hash, err = passwordHash(req.Password); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	user = newUser(req.Username, hash, req.Role, req.Projects)
+	if err = Users.Insert(ctx, user); err != nil {
+		if err != ErrUserExist {
+			log.Logger.Errorf("%s error, create user %s error: %v", m, req.Username, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "create user error: "+err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("user %s is created by %s", user.Username, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, user
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func UserList(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		m     = "apps.auth.UserList()"
+		users []*User
+		err   error
+	)
+
+	if users, err = Users.List(ctx); err != nil {
+		log.Logger.Errorf("%s error, list users error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list users error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, users
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// create api token of caller, token is only in response of this request
+func ApiTokenCreate(ctx *gin.Context) {
+	var (
+		rsp       = make(gin.H)
+		m         = "apps.auth.ApiTokenCreate()"
+		req       = new(ApiTokenRequest)
+		caller, _ = CallerOf(ctx)
+		random    = make([]byte, 24)
+		token     string
+		apiToken  *ApiToken
+		err       error
+	)
+
+	if err = ctx.BindJSON(req); err != nil || req.Name == "" || req.ExpireDays < 0 {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, name is required"
+		goto RESPONSE
+	}
+
+	if _, err = rand.Read(random); err != nil {
+		log.Logger.Errorf("%s error, generate api token error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "generate api token error"
+		goto RESPONSE
+	}
+	token = API_TOKEN_PREFIX + hex.EncodeToString(random)
+
+	apiToken = &ApiToken{
+		Id:         primitive.NewObjectID().Hex(),
+		Name:       req.Name,
+		Username:   caller.User.Username,
+		Hash:       tokenHash(token),
+		CreateTime: time.Now().Unix(),
+	}
+	if req.ExpireDays > 0 {
+		apiToken.ExpireTime = time.Now().AddDate(0, 0, req.ExpireDays).Unix()
+	}
+
+	if err = Users.InsertToken(ctx, apiToken); err != nil {
+		log.Logger.Errorf("%s error, save api token of %s error: %v", m, apiToken.Username, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "save api token error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, gin.H{"token": token, "apiToken": apiToken}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func ApiTokenList(ctx *gin.Context) {
+	var (
+		rsp       = make(gin.H)
+		m         = "apps.auth.ApiTokenList()"
+		caller, _ = CallerOf(ctx)
+		tokens    []*ApiToken
+		err       error
+	)
+
+	if tokens, err = Users.FindTokens(ctx, caller.User.Username); err != nil {
+		log.Logger.Errorf("%s error, find api tokens of %s error: %v", m, caller.User.Username, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "find api tokens error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, tokens
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func ApiTokenDelete(ctx *gin.Context) {
+	var (
+		rsp       = make(gin.H)
+		m         = "apps.auth.ApiTokenDelete()"
+		caller, _ = CallerOf(ctx)
+		err       error
+	)
+
+	if err = Users.DeleteToken(ctx, caller.User.Username, ctx.Param("id")); err != nil {
+		if err != ErrApiTokenNotFound {
+			log.Logger.Errorf("%s error, delete api token[%s] of %s error: %v", m, ctx.Param("id"), caller.User.Username, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "delete api token error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, ""
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
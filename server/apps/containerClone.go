@@ -0,0 +1,222 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/commons"
+	"iCloud/log"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rebuild configuration of container from its inspect, it is the reverse of containerConfInit and hostConfInit
+func containerConfFromInspect(detail *types.ContainerJSON) (conf *ContainerConfiguration) {
+	conf = &ContainerConfiguration{
+		ContainerName: strings.TrimPrefix(detail.Name, "/"),
+		SourceDir:     make([]string, 0),
+		ContainerPort: make([]string, 0),
+		HostPort:      make([]string, 0),
+		Commands:      make([]string, 0),
+		Gpus:          "0",
+	}
+
+	if detail.Config != nil {
+		conf.ImageName, conf.Pwd = detail.Config.Image, detail.Config.WorkingDir
+		conf.Project = detail.Config.Labels[LABEL_PROJECT]
+		// env and labels can not be told from those of image, only secrets are rebuilt
+		if label := detail.Config.Labels[LABEL_SECRETS]; label != "" {
+			conf.Secrets = secretRefsFromLabel(label)
+		}
+		conf.HealthCheck = healthCheckFromConfig(detail.Config.Healthcheck)
+		conf.Reschedule, _ = strconv.ParseBool(detail.Config.Labels[LABEL_RESCHEDULE])
+		// entry point of multi commands is script created in working dir by client, it may not exist on other host,
+		// so commands are rebuilt from deployment by caller
+		if len(detail.Config.Entrypoint) > 0 && !entryPointIsScript(detail.Config.Entrypoint) {
+			conf.Entrypoint = append(make([]string, 0, len(detail.Config.Entrypoint)), detail.Config.Entrypoint...)
+		}
+	}
+
+	if detail.HostConfig == nil {
+		return
+	}
+
+	if policy := detail.HostConfig.RestartPolicy; policy.Name != "" {
+		conf.RestartPolicy, conf.RestartMaxRetry = policy.Name, policy.MaximumRetryCount
+	}
+
+	for _, bind := range detail.HostConfig.Binds {
+		dirs := strings.Split(bind, ":")
+		if len(dirs) >= 2 && dirs[0] == dirs[1] && dirs[0] != "/etc/localtime" {
+			conf.SourceDir = append(conf.SourceDir, dirs[0])
+		}
+	}
+
+	// link in inspect is "/container:/linker/alias"
+	for _, link := range detail.HostConfig.Links {
+		if parts := strings.SplitN(link, ":", 2); len(parts) == 2 {
+			conf.Links = append(conf.Links, strings.TrimPrefix(parts[0], "/")+":"+parts[1][strings.LastIndex(parts[1], "/")+1:])
+		}
+	}
+
+	// alias of short id is added by docker
+	if mode := detail.HostConfig.NetworkMode; mode.IsUserDefined() || mode.IsHost() || mode.IsNone() {
+		conf.Network = string(mode)
+		if detail.NetworkSettings != nil && detail.NetworkSettings.Networks[conf.Network] != nil {
+			for _, alias := range detail.NetworkSettings.Networks[conf.Network].Aliases {
+				if !strings.HasPrefix(detail.ID, alias) {
+					conf.NetworkAliases = append(conf.NetworkAliases, alias)
+				}
+			}
+		}
+	}
+
+	for port, bindings := range detail.HostConfig.PortBindings {
+		if len(bindings) > 0 {
+			conf.ContainerPort = append(conf.ContainerPort, port.Port())
+			conf.HostPort = append(conf.HostPort, bindings[0].HostPort)
+		}
+	}
+
+	resources := detail.HostConfig.Resources
+	if resources.Memory > 0 {
+		conf.MaxMem = strconv.FormatFloat(float64(resources.Memory)/float64(commons.GB), 'f', -1, 64)
+	}
+	if resources.CPUQuota > 0 && resources.CPUPeriod > 0 {
+		conf.MaxCpu = strconv.FormatFloat(float64(resources.CPUQuota)/float64(resources.CPUPeriod), 'f', -1, 64)
+	}
+	for _, devReq := range resources.DeviceRequests {
+		if devReq.Count > 0 {
+			conf.Gpus = strconv.Itoa(devReq.Count)
+		}
+	}
+
+	return
+}
+
+func entryPointIsScript(entryPoint []string) bool {
+	return len(entryPoint) == 1 && entryPoint[0] == "./"+commons.CONTAINER_ENTRY_POINT_SCRIPT
+}
+
+// fields in request body override configuration, nothing is changed if body is empty
+func containerConfOverride(ctx *gin.Context, conf *ContainerConfiguration) (err error) {
+	var (
+		body []byte
+	)
+
+	if body, err = ioutil.ReadAll(ctx.Request.Body); err != nil {
+		return
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, conf)
+}
+
+// create a new container with configuration of an existing container, on the same host or on host of
+// "targetIp" and "targetPort" in url, fields in request body override the configuration of existing container
+func ContainerClone(ctx *gin.Context) {
+	var (
+		rsp           = make(gin.H)
+		m             = "apps.containerClone.ContainerClone()"
+		ip, id        = ctx.Param("ip"), ctx.Param("id")
+		remotePort    = ctx.Param("port")
+		targetIp      = ctx.DefaultQuery("targetIp", ip)
+		targetPort    = ctx.DefaultQuery("targetPort", remotePort)
+		cli           *client.Client
+		targetCli     *client.Client
+		err           error
+		release       func()
+		detail        types.ContainerJSON
+		containerConf *ContainerConfiguration
+		origin        *Deployment
+		deployment    *Deployment
+		entryPoint    []string
+	)
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_READ); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if detail, err = cli.ContainerInspect(context.TODO(), id); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] inspect error: %v", m, id, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "get container inspect error"
+		goto RESPONSE
+	}
+
+	containerConf = containerConfFromInspect(&detail)
+	containerConf.ContainerName = fmt.Sprintf("%s-%d", containerConf.ContainerName, time.Now().Unix())
+	if detail.Config != nil && entryPointIsScript(detail.Config.Entrypoint) {
+		if origin, err = deploymentConfigurationOf(ctx, id); err != nil {
+			if err != ErrDeploymentNotFound {
+				log.Logger.Errorf("%s error, get deployment of container[%s] error: %v", m, id, err)
+			}
+			rsp["ErrorCode"], rsp["Data"] = 1, "commands of container are not found in deployments"
+			goto RESPONSE
+		}
+		containerConf.Commands = append(containerConf.Commands, origin.Configuration.Commands...)
+	}
+
+	entryPoint = containerConf.Entrypoint
+	if err = containerConfOverride(ctx, containerConf); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+	// commands in request replace entry point of existing container
+	if len(containerConf.Commands) > 0 && reflect.DeepEqual(containerConf.Entrypoint, entryPoint) {
+		containerConf.Entrypoint = nil
+	}
+
+	if err = containerConf.confCheck(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerOwnerSet(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if release, err = containerQuotaCheck(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	defer release()
+
+	if host, ok := HostRegistry.ByIp(targetIp); ok {
+		containerConf.ClientIp, containerConf.RpcPort = host.Ip, host.GrpcPort
+	}
+
+	if targetCli, err = DockerClientPool.Get(targetIp, targetPort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api of target host error: "+err.Error()
+		goto RESPONSE
+	}
+
+	deployment = newDeployment(ctx, DEPLOY_ACTION_CREATE, targetIp, targetPort)
+	deployment.Configuration = containerConf
+	deployment.ContainerId, err = createContainer(targetCli, containerConf)
+	deployment.finish(err)
+	if err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, deployment
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,116 @@
+package apps
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"reflect"
+	"testing"
+)
+
+func inspectOf(config *container.Config, hostConfig *container.HostConfig, networks map[string]*network.EndpointSettings) *types.ContainerJSON {
+	return &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         "0123456789abcdef",
+			Name:       "/web",
+			HostConfig: hostConfig,
+		},
+		Config:          config,
+		NetworkSettings: &types.NetworkSettings{Networks: networks},
+	}
+}
+
+func TestContainerConfFromInspect(t *testing.T) {
+	tests := []struct {
+		name   string
+		detail *types.ContainerJSON
+		check  func(conf *ContainerConfiguration) bool
+	}{
+		{
+			"name without slash",
+			inspectOf(nil, nil, nil),
+			func(conf *ContainerConfiguration) bool { return conf.ContainerName == "web" && conf.Gpus == "0" },
+		},
+		{
+			"image, working dir and labels",
+			inspectOf(&container.Config{
+				Image:      "nginx:1.19",
+				WorkingDir: "/app",
+				Labels:     map[string]string{LABEL_PROJECT: "ml", LABEL_SECRETS: "DB_PASS=db,TOKEN=api", LABEL_RESCHEDULE: "true"},
+			}, nil, nil),
+			func(conf *ContainerConfiguration) bool {
+				return conf.ImageName == "nginx:1.19" && conf.Pwd == "/app" && conf.Project == "ml" && conf.Reschedule &&
+					reflect.DeepEqual(conf.Secrets, []SecretRef{{Env: "DB_PASS", Name: "db"}, {Env: "TOKEN", Name: "api"}})
+			},
+		},
+		{
+			"entry point with spaces in args is kept",
+			inspectOf(&container.Config{Entrypoint: []string{"sh", "-c", "echo hello world"}}, nil, nil),
+			func(conf *ContainerConfiguration) bool {
+				return len(conf.Commands) == 0 && reflect.DeepEqual(conf.Entrypoint, []string{"sh", "-c", "echo hello world"})
+			},
+		},
+		{
+			"entry point script of multi commands is not kept",
+			inspectOf(&container.Config{Entrypoint: []string{"./start.sh"}}, nil, nil),
+			func(conf *ContainerConfiguration) bool { return len(conf.Commands) == 0 && len(conf.Entrypoint) == 0 },
+		},
+		{
+			"binds of same dir, localtime is skipped",
+			inspectOf(nil, &container.HostConfig{Binds: []string{"/data:/data", "/etc/localtime:/etc/localtime:ro", "/src:/dst"}}, nil),
+			func(conf *ContainerConfiguration) bool { return reflect.DeepEqual(conf.SourceDir, []string{"/data"}) },
+		},
+		{
+			"restart policy and resources",
+			inspectOf(nil, &container.HostConfig{
+				RestartPolicy: container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3},
+				Resources: container.Resources{
+					Memory:         2 * 1024 * 1024 * 1024,
+					CPUQuota:       150000,
+					CPUPeriod:      100000,
+					DeviceRequests: []container.DeviceRequest{{Count: 2}},
+				},
+			}, nil),
+			func(conf *ContainerConfiguration) bool {
+				return conf.RestartPolicy == "on-failure" && conf.RestartMaxRetry == 3 && conf.MaxMem == "2" && conf.MaxCpu == "1.5" && conf.Gpus == "2"
+			},
+		},
+		{
+			"ports",
+			inspectOf(nil, &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8080"}}, "443/tcp": {}}}, nil),
+			func(conf *ContainerConfiguration) bool {
+				return reflect.DeepEqual(conf.ContainerPort, []string{"80"}) && reflect.DeepEqual(conf.HostPort, []string{"8080"})
+			},
+		},
+		{
+			"links",
+			inspectOf(nil, &container.HostConfig{Links: []string{"/db:/web/database", "/cache:/web/cache"}}, nil),
+			func(conf *ContainerConfiguration) bool {
+				return reflect.DeepEqual(conf.Links, []string{"db:database", "cache:cache"})
+			},
+		},
+		{
+			"user-defined network with aliases, short id is skipped",
+			inspectOf(nil, &container.HostConfig{NetworkMode: "backend"}, map[string]*network.EndpointSettings{
+				"backend": {Aliases: []string{"api", "0123456789ab"}},
+			}),
+			func(conf *ContainerConfiguration) bool {
+				return conf.Network == "backend" && reflect.DeepEqual(conf.NetworkAliases, []string{"api"})
+			},
+		},
+		{
+			"default network is not kept",
+			inspectOf(nil, &container.HostConfig{NetworkMode: "default"}, nil),
+			func(conf *ContainerConfiguration) bool { return conf.Network == "" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if conf := containerConfFromInspect(tt.detail); !tt.check(conf) {
+				t.Errorf("containerConfFromInspect() = %+v", conf)
+			}
+		})
+	}
+}
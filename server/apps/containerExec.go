@@ -0,0 +1,267 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"iCloud/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EXEC_MESSAGE_INPUT  = "input"
+	EXEC_MESSAGE_RESIZE = "resize"
+	EXEC_DEFAULT_CMD    = "/bin/sh"
+	EXEC_SESSION_KEEP   = 100 // count of closed sessions kept in memory
+)
+
+var (
+	execUpgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     execOriginCheck,
+	}
+	execSessions = newExecSessionStore()
+)
+
+// cookie of login token is sent by browser in websocket of any site, so page of other origin can not connect it,
+// request without origin is sent by scripts instead of browser
+func execOriginCheck(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// message sent by browser through websocket
+type ExecMessage struct {
+	Type string `json:"type"` // input or resize
+	Data string `json:"data"` // input of terminal
+	Rows uint   `json:"rows"`
+	Cols uint   `json:"cols"`
+}
+
+type ExecSession struct {
+	Id          string `json:"id"`
+	ContainerId string `json:"containerId"`
+	HostIp      string `json:"hostIp"`
+	Cmd         string `json:"cmd"`
+	User        string `json:"user"` // who opened the session
+	RemoteAddr  string `json:"remoteAddr"`
+	StartTime   int64  `json:"startTime"`
+	EndTime     int64  `json:"endTime"`  // 0 if session is open
+	Duration    int64  `json:"duration"` // seconds
+}
+
+type execSessionStore struct {
+	mu     sync.Mutex
+	open   map[string]*ExecSession
+	closed []*ExecSession
+}
+
+func newExecSessionStore() *execSessionStore {
+	return &execSessionStore{open: make(map[string]*ExecSession), closed: make([]*ExecSession, 0)}
+}
+
+func (s *execSessionStore) start(session *ExecSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.StartTime = time.Now().Unix()
+	s.open[session.Id] = session
+}
+
+func (s *execSessionStore) end(session *ExecSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.EndTime = time.Now().Unix()
+	session.Duration = session.EndTime - session.StartTime
+	delete(s.open, session.Id)
+	s.closed = append(s.closed, session)
+	if len(s.closed) > EXEC_SESSION_KEEP {
+		s.closed = s.closed[len(s.closed)-EXEC_SESSION_KEEP:]
+	}
+}
+
+func (s *execSessionStore) list() (sessions []ExecSession) {
+	var (
+		now = time.Now().Unix()
+	)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions = make([]ExecSession, 0, len(s.open)+len(s.closed))
+	for _, session := range s.open {
+		openSession := *session
+		openSession.Duration = now - openSession.StartTime
+		sessions = append(sessions, openSession)
+	}
+	for i := len(s.closed) - 1; i >= 0; i-- {
+		sessions = append(sessions, *s.closed[i])
+	}
+	return
+}
+
+// open a terminal in container by websocket, command is "cmd" in url, "/bin/sh" by default,
+// browser sends ExecMessage, and output of terminal is sent back as binary message
+func ContainerExec(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.containerExec.ContainerExec()"
+		ip, id   = ctx.Param("ip"), ctx.Param("id")
+		cli      *client.Client
+		err      error
+		execId   types.IDResponse
+		hijacked types.HijackedResponse
+		conn     *websocket.Conn
+		cmd      = ctx.DefaultQuery("cmd", EXEC_DEFAULT_CMD)
+		session  *ExecSession
+	)
+
+	// origin is checked before command runs, upgrader checks it only after exec is started
+	if !execOriginCheck(ctx.Request) {
+		log.Logger.Errorf("%s error, origin %s of request dose not match host %s", m, ctx.GetHeader("Origin"), ctx.Request.Host)
+		ctx.JSON(http.StatusForbidden, gin.H{"ErrorCode": 1, "Data": "origin of request is not allowed"})
+		return
+	}
+
+	// port of docker api is taken from host registry, port in url is used if it is given
+	if cli, err = DockerClientPool.Get(ip, ctx.Query("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if execId, err = cli.ContainerExecCreate(context.TODO(), id, types.ExecConfig{
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          strings.Fields(cmd),
+	}); err != nil {
+		log.Logger.Errorf("%s error, create exec in container[%s] error: %v", m, id, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "create exec in container error"
+		goto RESPONSE
+	}
+
+	if hijacked, err = cli.ContainerExecAttach(context.TODO(), execId.ID, types.ExecStartCheck{Tty: true}); err != nil {
+		log.Logger.Errorf("%s error, attach exec[%s] in container[%s] error: %v", m, execId.ID, id, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "attach exec in container error"
+		goto RESPONSE
+	}
+	defer hijacked.Close()
+
+	// response is written by upgrader if it fails
+	if conn, err = execUpgrader.Upgrade(ctx.Writer, ctx.Request, nil); err != nil {
+		log.Logger.Errorf("%s error, upgrade to websocket error: %v", m, err)
+		return
+	}
+	defer conn.Close()
+
+	session = &ExecSession{
+		Id:          execId.ID,
+		ContainerId: id,
+		HostIp:      ip,
+		Cmd:         cmd,
+		User:        requestCaller(ctx),
+		RemoteAddr:  ctx.ClientIP(),
+	}
+	execSessions.start(session)
+	log.Logger.Infof("exec session[%s] in container[%s] on %s is opened by %s", session.Id, id, ip, session.User)
+	defer func() {
+		execSessions.end(session)
+		log.Logger.Infof("exec session[%s] in container[%s] on %s opened by %s is closed after %d seconds", session.Id, id, ip, session.User, session.Duration)
+	}()
+
+	go execOutputCopy(conn, hijacked)
+	execInputCopy(conn, hijacked, cli, execId.ID)
+	return
+
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// output of terminal to browser, websocket is closed when terminal exits
+func execOutputCopy(conn *websocket.Conn, hijacked types.HijackedResponse) {
+	var (
+		buf = make([]byte, 4096)
+		n   int
+		err error
+	)
+	defer conn.Close()
+
+	for {
+		if n, err = hijacked.Reader.Read(buf); n > 0 {
+			if writeErr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); writeErr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// input of browser to terminal, return when websocket is closed
+func execInputCopy(conn *websocket.Conn, hijacked types.HijackedResponse, cli *client.Client, execId string) {
+	var (
+		m   = "apps.containerExec.execInputCopy()"
+		msg []byte
+		err error
+	)
+
+	for {
+		if _, msg, err = conn.ReadMessage(); err != nil {
+			return
+		}
+
+		execMsg := new(ExecMessage)
+		if err = json.Unmarshal(msg, execMsg); err != nil {
+			log.Logger.Errorf("%s error, exec message json unmarshal error: %v", m, err)
+			continue
+		}
+
+		switch execMsg.Type {
+		case EXEC_MESSAGE_INPUT:
+			if _, err = hijacked.Conn.Write([]byte(execMsg.Data)); err != nil {
+				return
+			}
+		case EXEC_MESSAGE_RESIZE:
+			if err = cli.ContainerExecResize(context.TODO(), execId, types.ResizeOptions{Height: execMsg.Rows, Width: execMsg.Cols}); err != nil {
+				log.Logger.Errorf("%s error, resize exec[%s] to %dx%d error: %v", m, execId, execMsg.Rows, execMsg.Cols, err)
+			}
+		default:
+			log.Logger.Errorf("%s error, unknown exec message type: %q", m, execMsg.Type)
+		}
+	}
+}
+
+// list open sessions and recently closed sessions
+// sessions of all users are listed to admin, and sessions of caller to others
+func ContainerExecSessions(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		user     = callerUser(ctx)
+		sessions = execSessions.list()
+		data     = make([]ExecSession, 0, len(sessions))
+	)
+	for _, session := range sessions {
+		if user.IsAdmin() || session.User == user.Username {
+			data = append(data, session)
+		}
+	}
+	rsp["ErrorCode"], rsp["Data"] = 0, data
+	ctx.JSON(http.StatusOK, rsp)
+}
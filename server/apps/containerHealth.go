@@ -0,0 +1,127 @@
+package apps
+
+import (
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RESTART_POLICY_NO             = "no"
+	RESTART_POLICY_ALWAYS         = "always"
+	RESTART_POLICY_UNLESS_STOPPED = "unless-stopped"
+	RESTART_POLICY_ON_FAILURE     = "on-failure"
+
+	LABEL_RESTART_POLICY = "iCloud.restartPolicy"
+	LABEL_RESCHEDULE     = "iCloud.reschedule" // "true" if container is created on other host when its host is offline
+)
+
+// docker healthcheck of container, times are in seconds and 0 is default of docker
+type HealthCheck struct {
+	Test        []string `json:"test"` // ["CMD", "cmd", "arg"...], ["CMD-SHELL", "command"] or ["NONE"]
+	Interval    int      `json:"interval"`
+	Timeout     int      `json:"timeout"`
+	StartPeriod int      `json:"startPeriod"`
+	Retries     int      `json:"retries"`
+}
+
+// item of container list with restart policy and health
+type ContainerListItem struct {
+	types.Container
+	RestartPolicy string `json:"RestartPolicy"`
+	Health        string `json:"Health"` // starting, healthy or unhealthy, it is empty if container has no healthcheck
+	Reschedule    bool   `json:"Reschedule"`
+}
+
+func restartPolicyCheck(conf *ContainerConfiguration) error {
+	switch conf.RestartPolicy {
+	case "", RESTART_POLICY_NO, RESTART_POLICY_ALWAYS, RESTART_POLICY_UNLESS_STOPPED:
+		if conf.RestartMaxRetry != 0 {
+			return errors.New("restartMaxRetry is only used by restart policy on-failure")
+		}
+	case RESTART_POLICY_ON_FAILURE:
+		if conf.RestartMaxRetry < 0 {
+			return errors.New("restartMaxRetry can not be less than 0")
+		}
+	default:
+		return errors.New("restart policy is one of no, always, unless-stopped and on-failure")
+	}
+	return nil
+}
+
+func (h *HealthCheck) check() error {
+	if len(h.Test) == 0 {
+		return errors.New("test of healthcheck is null")
+	}
+	switch h.Test[0] {
+	case "NONE":
+	case "CMD", "CMD-SHELL":
+		if len(h.Test) < 2 || h.Test[1] == "" {
+			return errors.New("command of healthcheck is null")
+		}
+	default:
+		return errors.New("test of healthcheck starts with NONE, CMD or CMD-SHELL")
+	}
+	if h.Interval < 0 || h.Timeout < 0 || h.StartPeriod < 0 || h.Retries < 0 {
+		return errors.New("times and retries of healthcheck can not be less than 0")
+	}
+	return nil
+}
+
+func (h *HealthCheck) config() *container.HealthConfig {
+	return &container.HealthConfig{
+		Test:        h.Test,
+		Interval:    time.Duration(h.Interval) * time.Second,
+		Timeout:     time.Duration(h.Timeout) * time.Second,
+		StartPeriod: time.Duration(h.StartPeriod) * time.Second,
+		Retries:     h.Retries,
+	}
+}
+
+func healthCheckFromConfig(health *container.HealthConfig) *HealthCheck {
+	if health == nil || len(health.Test) == 0 {
+		return nil
+	}
+	return &HealthCheck{
+		Test:        health.Test,
+		Interval:    int(health.Interval / time.Second),
+		Timeout:     int(health.Timeout / time.Second),
+		StartPeriod: int(health.StartPeriod / time.Second),
+		Retries:     health.Retries,
+	}
+}
+
+func restartPolicyOf(conf *ContainerConfiguration) container.RestartPolicy {
+	if conf.RestartPolicy == "" {
+		return container.RestartPolicy{Name: RESTART_POLICY_NO}
+	}
+	return container.RestartPolicy{Name: conf.RestartPolicy, MaximumRetryCount: conf.RestartMaxRetry}
+}
+
+// health in status of container list, e.g. "Up 5 minutes (healthy)"
+func healthFromStatus(status string) string {
+	for _, health := range []string{types.Unhealthy, types.Healthy, types.Starting} {
+		if strings.Contains(status, "("+health+")") || strings.Contains(status, "(health: "+health+")") {
+			return health
+		}
+	}
+	return ""
+}
+
+// restart policy is not in container list of docker, so it is kept in label when container is created
+func containerListItems(containers []types.Container) []*ContainerListItem {
+	items := make([]*ContainerListItem, 0, len(containers))
+	for _, c := range containers {
+		reschedule, _ := strconv.ParseBool(c.Labels[LABEL_RESCHEDULE])
+		items = append(items, &ContainerListItem{
+			Container:     c,
+			RestartPolicy: c.Labels[LABEL_RESTART_POLICY],
+			Health:        healthFromStatus(c.Status),
+			Reschedule:    reschedule,
+		})
+	}
+	return items
+}
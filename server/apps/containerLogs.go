@@ -0,0 +1,225 @@
+package apps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/log"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	LOG_STREAM_STDIN  = "stdin"
+	LOG_STREAM_STDOUT = "stdout"
+	LOG_STREAM_STDERR = "stderr"
+
+	// header of each frame in docker log stream: [stream type, 0, 0, 0, size(4 bytes, big endian)]
+	logFrameHeaderLen = 8
+)
+
+var logStreamNames = map[byte]string{0: LOG_STREAM_STDIN, 1: LOG_STREAM_STDOUT, 2: LOG_STREAM_STDERR}
+
+type LogLine struct {
+	Stream  string `json:"stream"`
+	Time    string `json:"time"`
+	Content string `json:"content"`
+}
+
+func (l *LogLine) String() string {
+	if l.Time == "" {
+		return l.Content
+	}
+	return l.Time + " " + l.Content
+}
+
+func newLogLine(stream string, line []byte, timestamps bool) *LogLine {
+	var (
+		logLine = &LogLine{Stream: stream}
+		content = strings.TrimRight(string(line), "\r\n")
+	)
+
+	if timestamps {
+		if i := strings.IndexByte(content, ' '); i > 0 {
+			logLine.Time, content = content[:i], content[i+1:]
+		}
+	}
+	logLine.Content = content
+
+	return logLine
+}
+
+// read log stream of docker and call handler with each line,
+// log of container with tty is raw stream, otherwise it is multiplexed by frame header
+func logDemux(r io.Reader, tty, timestamps bool, handler func(line *LogLine) error) (err error) {
+	var (
+		header  = make([]byte, logFrameHeaderLen)
+		payload []byte
+		buffers = make(map[string]*bytes.Buffer)
+		stream  string
+		exist   bool
+	)
+
+	if tty {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if err = handler(newLogLine(LOG_STREAM_STDOUT, scanner.Bytes(), timestamps)); err != nil {
+				return
+			}
+		}
+		return scanner.Err()
+	}
+
+	// the last line of each stream may be without '\n'
+	defer func() {
+		for stream, buf := range buffers {
+			if buf.Len() > 0 && err == nil {
+				err = handler(newLogLine(stream, buf.Bytes(), timestamps))
+			}
+		}
+	}()
+
+	for {
+		if _, err = io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return
+		}
+
+		if stream, exist = logStreamNames[header[0]]; !exist {
+			stream = LOG_STREAM_STDOUT
+		}
+
+		payload = make([]byte, binary.BigEndian.Uint32(header[4:logFrameHeaderLen]))
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return
+		}
+
+		if _, exist = buffers[stream]; !exist {
+			buffers[stream] = new(bytes.Buffer)
+		}
+		buf := buffers[stream]
+		buf.Write(payload)
+
+		for {
+			i := bytes.IndexByte(buf.Bytes(), '\n')
+			if i < 0 {
+				break
+			}
+			if err = handler(newLogLine(stream, buf.Next(i+1), timestamps)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// stream container log by server-sent events, options are in url:
+// follow=true, tail=100, since=<timestamp or RFC3339>, until=<timestamp or RFC3339>, stdout=false, stderr=false
+// each log line is sent as event "log", event "end" is sent when log is finished, and event "error" when error occurs
+func ContainerLogStream(ctx *gin.Context) {
+	var (
+		cli          *client.Client
+		err          error
+		detail       types.ContainerJSON
+		containerLog io.ReadCloser
+		m            = "apps.containerLogs.ContainerLogStream()"
+		ip, id       = ctx.Param("ip"), ctx.Param("id")
+		remotePort   = ctx.Param("port")
+		reqCtx       = ctx.Request.Context()
+		logOption    = types.ContainerLogsOptions{
+			ShowStdout: ctx.DefaultQuery("stdout", "true") != "false",
+			ShowStderr: ctx.DefaultQuery("stderr", "true") != "false",
+			Since:      ctx.Query("since"),
+			Until:      ctx.Query("until"),
+			Follow:     ctx.Query("follow") == "true",
+			Tail:       ctx.DefaultQuery("tail", "100"),
+			Timestamps: true,
+		}
+	)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "connect to remote docker api error: " + err.Error()})
+		return
+	}
+
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_READ); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": err.Error()})
+		return
+	}
+
+	if detail, err = cli.ContainerInspect(reqCtx, id); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] inspect error: %v", m, id, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "get container inspect error"})
+		return
+	}
+
+	// request context is canceled when browser disconnects, which closes log stream of docker
+	if containerLog, err = cli.ContainerLogs(reqCtx, id, logOption); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] log error: %v", m, id, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "get container log error"})
+		return
+	}
+	defer containerLog.Close()
+
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+
+	err = logDemux(containerLog, detail.Config != nil && detail.Config.Tty, true, func(line *LogLine) error {
+		ctx.SSEvent("log", line)
+		ctx.Writer.Flush()
+		return reqCtx.Err()
+	})
+
+	switch {
+	case reqCtx.Err() != nil:
+		log.Logger.Debugf("log stream of container[%s] is closed by browser", id)
+	case err != nil:
+		log.Logger.Errorf("%s error, read container[%s] log error: %v", m, id, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "read container log error"})
+	default:
+		ctx.SSEvent("end", gin.H{"ErrorCode": 0, "Data": ""})
+	}
+	ctx.Writer.Flush()
+}
+
+// read log lines of container which is not followed
+func containerLogLines(cli *client.Client, id string, logOption types.ContainerLogsOptions) (lines []*LogLine, err error) {
+	var (
+		m            = "apps.containerLogs.containerLogLines()"
+		detail       types.ContainerJSON
+		containerLog io.ReadCloser
+	)
+
+	logOption.Follow = false
+
+	if detail, err = cli.ContainerInspect(context.TODO(), id); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] inspect error: %v", m, id, err)
+		return
+	}
+
+	if containerLog, err = cli.ContainerLogs(context.TODO(), id, logOption); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] log error: %v", m, id, err)
+		return
+	}
+	defer containerLog.Close()
+
+	lines = make([]*LogLine, 0)
+	err = logDemux(containerLog, detail.Config != nil && detail.Config.Tty, logOption.Timestamps, func(line *LogLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+
+	return
+}
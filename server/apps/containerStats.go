@@ -0,0 +1,249 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/log"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resource usage of container, normalised from docker stats
+type ContainerResourceStats struct {
+	ContainerId     string  `json:"containerId"`
+	Name            string  `json:"name"`
+	Time            int64   `json:"time"`
+	CpuPercent      float64 `json:"cpuPercent"`      // percent of one cpu core, 200 means 2 cores are used
+	CpuQuota        float64 `json:"cpuQuota"`        // cores allowed by CPUQuota, 0 if unlimited
+	CpuQuotaPercent float64 `json:"cpuQuotaPercent"` // percent of CpuQuota used, 0 if unlimited
+	MemUsage        uint64  `json:"memUsage"`        // bytes, without page cache
+	MemLimit        uint64  `json:"memLimit"`        // bytes
+	MemPercent      float64 `json:"memPercent"`
+	NetRx           uint64  `json:"netRx"` // bytes
+	NetTx           uint64  `json:"netTx"` // bytes
+	BlockRead       uint64  `json:"blockRead"`  // bytes
+	BlockWrite      uint64  `json:"blockWrite"` // bytes
+	Pids            uint64  `json:"pids"`
+}
+
+func newContainerResourceStats(stats *types.StatsJSON, cpuQuota float64) (resourceStats *ContainerResourceStats) {
+	var (
+		cpuDelta    = float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+		systemDelta = float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+		onlineCpus  = float64(stats.CPUStats.OnlineCPUs)
+	)
+
+	resourceStats = &ContainerResourceStats{
+		ContainerId: stats.ID,
+		Name:        strings.TrimPrefix(stats.Name, "/"),
+		Time:        stats.Read.Unix(),
+		CpuQuota:    cpuQuota,
+		MemLimit:    stats.MemoryStats.Limit,
+		Pids:        stats.PidsStats.Current,
+	}
+
+	if onlineCpus == 0 {
+		onlineCpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		resourceStats.CpuPercent = cpuDelta / systemDelta * onlineCpus * 100
+	}
+	if cpuQuota > 0 {
+		resourceStats.CpuQuotaPercent = resourceStats.CpuPercent / cpuQuota
+	}
+
+	resourceStats.MemUsage = stats.MemoryStats.Usage
+	if cache, exist := stats.MemoryStats.Stats["cache"]; exist && cache < resourceStats.MemUsage {
+		resourceStats.MemUsage -= cache
+	}
+	if resourceStats.MemLimit > 0 {
+		resourceStats.MemPercent = float64(resourceStats.MemUsage) / float64(resourceStats.MemLimit) * 100
+	}
+
+	for _, network := range stats.Networks {
+		resourceStats.NetRx += network.RxBytes
+		resourceStats.NetTx += network.TxBytes
+	}
+
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			resourceStats.BlockRead += entry.Value
+		case "write":
+			resourceStats.BlockWrite += entry.Value
+		}
+	}
+
+	return resourceStats
+}
+
+// cpu cores allowed by CPUQuota which is set by resourceConfInit
+func containerCpuQuota(cli *client.Client, id string) (cpuQuota float64, err error) {
+	var (
+		detail types.ContainerJSON
+	)
+
+	if detail, err = cli.ContainerInspect(context.TODO(), id); err != nil {
+		return
+	}
+
+	if detail.HostConfig != nil && detail.HostConfig.CPUQuota > 0 && detail.HostConfig.CPUPeriod > 0 {
+		cpuQuota = float64(detail.HostConfig.CPUQuota) / float64(detail.HostConfig.CPUPeriod)
+	} else if detail.HostConfig != nil && detail.HostConfig.NanoCPUs > 0 {
+		cpuQuota = float64(detail.HostConfig.NanoCPUs) / 1e9
+	}
+	return
+}
+
+// read stats of container once
+func containerStatsSnapshot(ctx context.Context, cli *client.Client, id string) (resourceStats *ContainerResourceStats, err error) {
+	var (
+		m        = "apps.containerStats.containerStatsSnapshot()"
+		cpuQuota float64
+		stats    types.ContainerStats
+		statsObj = new(types.StatsJSON)
+	)
+
+	if cpuQuota, err = containerCpuQuota(cli, id); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] inspect error: %v", m, id, err)
+		return
+	}
+
+	if stats, err = cli.ContainerStats(ctx, id, false); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] stats error: %v", m, id, err)
+		return
+	}
+	defer stats.Body.Close()
+
+	if err = json.NewDecoder(stats.Body).Decode(statsObj); err != nil {
+		log.Logger.Errorf("%s error, container[%s] stats json decode error: %v", m, id, err)
+		return
+	}
+
+	return newContainerResourceStats(statsObj, cpuQuota), nil
+}
+
+// stats of one container, streamed by server-sent events "stats" if "stream=true" is in url
+func ContainerStats(ctx *gin.Context) {
+	var (
+		rsp           = make(gin.H)
+		m             = "apps.containerStats.ContainerStats()"
+		ip, id        = ctx.Param("ip"), ctx.Param("id")
+		remotePort    = ctx.Param("port")
+		cli           *client.Client
+		err           error
+		cpuQuota      float64
+		stats         types.ContainerStats
+		resourceStats *ContainerResourceStats
+		decoder       *json.Decoder
+		reqCtx        = ctx.Request.Context()
+	)
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_READ); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if ctx.Query("stream") != "true" {
+		if resourceStats, err = containerStatsSnapshot(reqCtx, cli, id); err != nil {
+			rsp["ErrorCode"], rsp["Data"] = 1, "get container stats error"
+			goto RESPONSE
+		}
+		rsp["ErrorCode"], rsp["Data"] = 0, resourceStats
+		goto RESPONSE
+	}
+
+	if cpuQuota, err = containerCpuQuota(cli, id); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] inspect error: %v", m, id, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "get container inspect error"
+		goto RESPONSE
+	}
+
+	// docker stats stream is closed when browser disconnects
+	if stats, err = cli.ContainerStats(reqCtx, id, true); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] stats error: %v", m, id, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "get container stats error"
+		goto RESPONSE
+	}
+	defer stats.Body.Close()
+	decoder = json.NewDecoder(stats.Body)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Stream(func(w io.Writer) bool {
+		statsObj := new(types.StatsJSON)
+		if err = decoder.Decode(statsObj); err != nil {
+			if reqCtx.Err() == nil && err != io.EOF {
+				log.Logger.Errorf("%s error, container[%s] stats json decode error: %v", m, id, err)
+			}
+			return false
+		}
+		ctx.SSEvent("stats", newContainerResourceStats(statsObj, cpuQuota))
+		return true
+	})
+	return
+
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// stats of all running containers on one host
+func HostContainerStats(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.containerStats.HostContainerStats()"
+		ip         = ctx.Param("ip")
+		remotePort = ctx.Param("port")
+		cli        *client.Client
+		err        error
+		containers []types.Container
+		data       []*ContainerResourceStats
+		wg         = sync.WaitGroup{}
+		mu         = sync.Mutex{}
+	)
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if containers, err = cli.ContainerList(context.TODO(), types.ContainerListOptions{}); err != nil {
+		log.Logger.Errorf("%s error, list running containers on host[%s] error: %v", m, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list containers error"
+		goto RESPONSE
+	}
+
+	containers = containersVisible(ctx, containers)
+	data = make([]*ContainerResourceStats, 0, len(containers))
+	for _, c := range containers {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			statsCtx, statsCancel := context.WithTimeout(context.TODO(), time.Second*5)
+			defer statsCancel()
+			if resourceStats, statsErr := containerStatsSnapshot(statsCtx, cli, id); statsErr == nil {
+				mu.Lock()
+				data = append(data, resourceStats)
+				mu.Unlock()
+			}
+		}(c.ID)
+	}
+	wg.Wait()
+
+	rsp["ErrorCode"], rsp["Data"] = 0, data
+
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,253 @@
+package apps
+
+import (
+	"context"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"iCloud/commons"
+	"iCloud/log"
+	"net/http"
+	"time"
+)
+
+const (
+	DEPLOY_ACTION_CREATE     = "create"
+	DEPLOY_ACTION_START      = "start"
+	DEPLOY_ACTION_STOP       = "stop"
+	DEPLOY_ACTION_REMOVE     = "remove"
+	DEPLOY_ACTION_RESCHEDULE = "reschedule" // container is created on other host by supervisor when its host is offline
+
+	DEPLOY_STATUS_SUCCEEDED = "succeeded"
+	DEPLOY_STATUS_FAILED    = "failed"
+)
+
+var Deployments = NewMemoryDeploymentRepository()
+
+// one create/start/stop/remove of container
+type Deployment struct {
+	Id            string                  `json:"id" bson:"_id"`
+	Action        string                  `json:"action" bson:"action"`
+	Status        string                  `json:"status" bson:"status"`
+	Error         string                  `json:"error" bson:"error"`
+	ContainerId   string                  `json:"containerId" bson:"containerId"`
+	ContainerName string                  `json:"containerName" bson:"containerName"`
+	HostIp        string                  `json:"hostIp" bson:"hostIp"`
+	HostPort      string                  `json:"hostPort" bson:"hostPort"` // docker remote api port
+	Configuration *ContainerConfiguration `json:"configuration" bson:"configuration"`
+	Caller        string                  `json:"caller" bson:"caller"`
+	CreateTime    int64                   `json:"createTime" bson:"createTime"`
+	FinishTime    int64                   `json:"finishTime" bson:"finishTime"`
+}
+
+// who sends the request, it is username if request is authenticated
+func requestCaller(ctx *gin.Context) string {
+	if caller, ok := CallerOf(ctx); ok && caller.User != nil {
+		return caller.User.Username
+	}
+	return ctx.ClientIP()
+}
+
+func newDeployment(ctx *gin.Context, action, hostIp, hostPort string) *Deployment {
+	return &Deployment{
+		Id:         primitive.NewObjectID().Hex(),
+		Action:     action,
+		HostIp:     hostIp,
+		HostPort:   hostPort,
+		Caller:     requestCaller(ctx),
+		CreateTime: time.Now().Unix(),
+	}
+}
+
+// configuration of container in its last successful deployment, ErrDeploymentNotFound if container is not created by iCloud
+func deploymentConfigurationOf(ctx context.Context, containerId string) (*Deployment, error) {
+	deployments, err := Deployments.Find(ctx, &DeploymentFilter{ContainerId: containerId})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments {
+		if d.Configuration != nil && d.Status == DEPLOY_STATUS_SUCCEEDED {
+			return d, nil
+		}
+	}
+	return nil, ErrDeploymentNotFound
+}
+
+// save deployment with result of action, error of saving is only logged to not fail the action
+func (d *Deployment) finish(err error) {
+	var (
+		m = "apps.deployment.finish()"
+	)
+
+	d.FinishTime, d.Status = time.Now().Unix(), DEPLOY_STATUS_SUCCEEDED
+	if err != nil {
+		d.Status, d.Error = DEPLOY_STATUS_FAILED, err.Error()
+	}
+	if d.Configuration != nil {
+		if d.ContainerName == "" {
+			d.ContainerName = d.Configuration.ContainerName
+		}
+		// credentials of registry are not stored
+		conf := *d.Configuration
+		conf.RegistryAuth = nil
+		d.Configuration = &conf
+	}
+
+	mongoCtx, mongoCancel := context.WithTimeout(context.TODO(), commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	if saveErr := Deployments.Insert(mongoCtx, d); saveErr != nil {
+		log.Logger.Errorf("%s error, save %s deployment of container[%s] on %s error: %v", m, d.Action, d.ContainerId, d.HostIp, saveErr)
+	}
+}
+
+// deployment is owned by its caller, and it is in project of its configuration
+func deploymentAllowed(user *User, d *Deployment, permission string) bool {
+	project := ""
+	if d.Configuration != nil {
+		project = d.Configuration.Project
+	}
+	return user.Allowed(d.Caller, project, permission)
+}
+
+func DeploymentList(ctx *gin.Context) {
+	var (
+		rsp         = make(gin.H)
+		m           = "apps.deployment.DeploymentList()"
+		filter      = new(DeploymentFilter)
+		deployments []*Deployment
+		err         error
+	)
+
+	if err = ctx.ShouldBindQuery(filter); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "query param error"
+		goto RESPONSE
+	}
+	if user := callerUser(ctx); !user.IsAdmin() {
+		filter.visibleTo(user)
+	}
+
+	if deployments, err = Deployments.Find(ctx, filter); err != nil {
+		log.Logger.Errorf("%s error, find deployments error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "find deployments error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, deployments
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func DeploymentDetail(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.deployment.DeploymentDetail()"
+		deployment *Deployment
+		err        error
+	)
+
+	if deployment, err = Deployments.Get(ctx, ctx.Param("id")); err != nil {
+		if err != ErrDeploymentNotFound {
+			log.Logger.Errorf("%s error, get deployment[%s] error: %v", m, ctx.Param("id"), err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get deployment error"
+		goto RESPONSE
+	}
+
+	if !deploymentAllowed(callerUser(ctx), deployment, PERMISSION_READ) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, deployment
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// create container again with configuration of a create deployment,
+// on the same host or on host of "ip" and "port" in url, with name "name" in url if it is given,
+// fields in request body override the stored configuration
+func DeploymentRerun(ctx *gin.Context) {
+	var (
+		rsp           = make(gin.H)
+		m             = "apps.deployment.DeploymentRerun()"
+		deployment    *Deployment
+		rerun         *Deployment
+		containerConf ContainerConfiguration
+		cli           *client.Client
+		err           error
+		release       func()
+		hostIp        = ctx.Query("ip")
+		remotePort    = ctx.Query("port")
+	)
+
+	if deployment, err = Deployments.Get(ctx, ctx.Param("id")); err != nil {
+		if err != ErrDeploymentNotFound {
+			log.Logger.Errorf("%s error, get deployment[%s] error: %v", m, ctx.Param("id"), err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get deployment error"
+		goto RESPONSE
+	}
+
+	if !deploymentAllowed(callerUser(ctx), deployment, PERMISSION_READ) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	if (deployment.Action != DEPLOY_ACTION_CREATE && deployment.Action != DEPLOY_ACTION_RESCHEDULE) || deployment.Configuration == nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "only create deployment can be run again"
+		goto RESPONSE
+	}
+
+	if hostIp == "" {
+		hostIp, remotePort = deployment.HostIp, deployment.HostPort
+	}
+
+	containerConf = *deployment.Configuration
+	if name := ctx.Query("name"); name != "" {
+		containerConf.ContainerName = name
+	}
+
+	if err = containerConfOverride(ctx, &containerConf); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+
+	if err = containerConf.confCheck(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerOwnerSet(ctx, &containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if release, err = containerQuotaCheck(ctx, &containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	defer release()
+
+	if host, ok := HostRegistry.ByIp(hostIp); ok {
+		containerConf.ClientIp, containerConf.RpcPort = host.Ip, host.GrpcPort
+	}
+
+	if cli, err = DockerClientPool.Get(hostIp, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	rerun = newDeployment(ctx, DEPLOY_ACTION_CREATE, hostIp, remotePort)
+	rerun.Configuration = &containerConf
+	rerun.ContainerId, err = createContainer(cli, &containerConf)
+	rerun.finish(err)
+	if err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, rerun
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,175 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"sync"
+)
+
+const MONGO_COLLECTION_DEPLOYMENT = "deployments"
+
+var ErrDeploymentNotFound = errors.New("deployment record dose not exist")
+
+// storage of deployment history, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type DeploymentRepository interface {
+	Insert(ctx context.Context, deployment *Deployment) error
+	Get(ctx context.Context, id string) (*Deployment, error)
+	Find(ctx context.Context, filter *DeploymentFilter) ([]*Deployment, error)
+}
+
+// empty field is not used to filter
+type DeploymentFilter struct {
+	ContainerId   string `form:"containerId"`
+	ContainerName string `form:"containerName"`
+	HostIp        string `form:"ip"`
+	Action        string `form:"action"`
+	Caller        string `form:"caller"`
+	Limit         int64  `form:"limit"` // newest records are returned, 0 is no limit
+
+	// only deployments of the user or in the projects are found if the user is set
+	visibleUser     string
+	visibleProjects []string
+}
+
+// deployments which can be read by non-admin user
+func (f *DeploymentFilter) visibleTo(user *User) {
+	f.visibleUser, f.visibleProjects = user.Username, user.Projects
+}
+
+func (f *DeploymentFilter) match(d *Deployment) bool {
+	return (f.ContainerId == "" || f.ContainerId == d.ContainerId) &&
+		(f.ContainerName == "" || f.ContainerName == d.ContainerName) &&
+		(f.HostIp == "" || f.HostIp == d.HostIp) &&
+		(f.Action == "" || f.Action == d.Action) &&
+		(f.Caller == "" || f.Caller == d.Caller) &&
+		(f.visibleUser == "" || f.visibleUser == d.Caller || (d.Configuration != nil && d.Configuration.Project != "" && stringIn(d.Configuration.Project, f.visibleProjects)))
+}
+
+func stringIn(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *DeploymentFilter) bson() bson.M {
+	filter := bson.M{}
+	if f.ContainerId != "" {
+		filter["containerId"] = f.ContainerId
+	}
+	if f.ContainerName != "" {
+		filter["containerName"] = f.ContainerName
+	}
+	if f.HostIp != "" {
+		filter["hostIp"] = f.HostIp
+	}
+	if f.Action != "" {
+		filter["action"] = f.Action
+	}
+	if f.Caller != "" {
+		filter["caller"] = f.Caller
+	}
+	if f.visibleUser != "" {
+		filter["$or"] = bson.A{
+			bson.M{"caller": f.visibleUser},
+			bson.M{"configuration.project": bson.M{"$in": f.visibleProjects}},
+		}
+	}
+	return filter
+}
+
+type mongoDeploymentRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoDeploymentRepository(m *commons.MONGO) DeploymentRepository {
+	return &mongoDeploymentRepository{collection: m.Collection(MONGO_COLLECTION_DEPLOYMENT)}
+}
+
+func (r *mongoDeploymentRepository) Insert(ctx context.Context, deployment *Deployment) (err error) {
+	_, err = r.collection.InsertOne(ctx, deployment)
+	return
+}
+
+func (r *mongoDeploymentRepository) Get(ctx context.Context, id string) (deployment *Deployment, err error) {
+	deployment = new(Deployment)
+	if err = r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(deployment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrDeploymentNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoDeploymentRepository) Find(ctx context.Context, filter *DeploymentFilter) (deployments []*Deployment, err error) {
+	var (
+		cursor  *mongo.Cursor
+		findOpt = options.Find().SetSort(bson.D{{Key: "createTime", Value: -1}})
+	)
+	if filter.Limit > 0 {
+		findOpt.SetLimit(filter.Limit)
+	}
+
+	if cursor, err = r.collection.Find(ctx, filter.bson(), findOpt); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	deployments = make([]*Deployment, 0)
+	err = cursor.All(ctx, &deployments)
+	return
+}
+
+type memoryDeploymentRepository struct {
+	mu          sync.RWMutex
+	deployments []*Deployment
+}
+
+func NewMemoryDeploymentRepository() DeploymentRepository {
+	return &memoryDeploymentRepository{deployments: make([]*Deployment, 0)}
+}
+
+func (r *memoryDeploymentRepository) Insert(ctx context.Context, deployment *Deployment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := *deployment
+	r.deployments = append(r.deployments, &d)
+	return nil
+}
+
+func (r *memoryDeploymentRepository) Get(ctx context.Context, id string) (*Deployment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.deployments {
+		if d.Id == id {
+			deployment := *d
+			return &deployment, nil
+		}
+	}
+	return nil, ErrDeploymentNotFound
+}
+
+func (r *memoryDeploymentRepository) Find(ctx context.Context, filter *DeploymentFilter) ([]*Deployment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// newest first
+	deployments := make([]*Deployment, 0)
+	for i := len(r.deployments) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && int64(len(deployments)) >= filter.Limit {
+			break
+		}
+		if filter.match(r.deployments[i]) {
+			deployment := *r.deployments[i]
+			deployments = append(deployments, &deployment)
+		}
+	}
+	return deployments, nil
+}
@@ -0,0 +1,198 @@
+package apps
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeploymentFilterMatch(t *testing.T) {
+	d := &Deployment{
+		ContainerId:   "c1",
+		ContainerName: "web",
+		HostIp:        "192.168.1.152",
+		Action:        DEPLOY_ACTION_CREATE,
+		Caller:        "alice",
+		Configuration: &ContainerConfiguration{Project: "ml"},
+	}
+
+	tests := []struct {
+		name   string
+		filter *DeploymentFilter
+		user   *User
+		want   bool
+	}{
+		{"empty filter", &DeploymentFilter{}, nil, true},
+		{"container id", &DeploymentFilter{ContainerId: "c1"}, nil, true},
+		{"other container id", &DeploymentFilter{ContainerId: "c2"}, nil, false},
+		{"all fields", &DeploymentFilter{ContainerId: "c1", ContainerName: "web", HostIp: "192.168.1.152", Action: DEPLOY_ACTION_CREATE, Caller: "alice"}, nil, true},
+		{"other action", &DeploymentFilter{Action: DEPLOY_ACTION_REMOVE}, nil, false},
+		{"other host", &DeploymentFilter{HostIp: "192.168.1.153"}, nil, false},
+		{"visible to caller", &DeploymentFilter{}, &User{Username: "alice"}, true},
+		{"visible to project member", &DeploymentFilter{}, &User{Username: "bob", Projects: []string{"web", "ml"}}, true},
+		{"invisible to others", &DeploymentFilter{}, &User{Username: "bob", Projects: []string{"web"}}, false},
+		{"visible but other caller", &DeploymentFilter{Caller: "bob"}, &User{Username: "alice"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.user != nil {
+				tt.filter.visibleTo(tt.user)
+			}
+			if got := tt.filter.match(d); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeploymentFilterMatchWithoutProject(t *testing.T) {
+	filter := &DeploymentFilter{}
+	filter.visibleTo(&User{Username: "bob", Projects: []string{""}})
+
+	// deployment without project is visible only to its caller
+	for _, d := range []*Deployment{{Caller: "alice"}, {Caller: "alice", Configuration: &ContainerConfiguration{}}} {
+		if filter.match(d) {
+			t.Errorf("match() of %+v = true, want false", d)
+		}
+	}
+}
+
+func TestMemoryDeploymentRepository(t *testing.T) {
+	var (
+		ctx  = context.TODO()
+		repo = NewMemoryDeploymentRepository()
+	)
+
+	for _, d := range []*Deployment{
+		{Id: "1", ContainerId: "c1", Action: DEPLOY_ACTION_CREATE},
+		{Id: "2", ContainerId: "c1", Action: DEPLOY_ACTION_START},
+		{Id: "3", ContainerId: "c2", Action: DEPLOY_ACTION_CREATE},
+		{Id: "4", ContainerId: "c1", Action: DEPLOY_ACTION_STOP},
+	} {
+		if err := repo.Insert(ctx, d); err != nil {
+			t.Fatalf("Insert() error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter *DeploymentFilter
+		want   []string
+	}{
+		{"all newest first", &DeploymentFilter{}, []string{"4", "3", "2", "1"}},
+		{"limit", &DeploymentFilter{Limit: 2}, []string{"4", "3"}},
+		{"container", &DeploymentFilter{ContainerId: "c1"}, []string{"4", "2", "1"}},
+		{"container with limit", &DeploymentFilter{ContainerId: "c1", Limit: 1}, []string{"4"}},
+		{"action", &DeploymentFilter{Action: DEPLOY_ACTION_CREATE}, []string{"3", "1"}},
+		{"no match", &DeploymentFilter{ContainerId: "c3"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployments, err := repo.Find(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("Find() error: %v", err)
+			}
+			got := make([]string, 0, len(deployments))
+			for _, d := range deployments {
+				got = append(got, d.Id)
+			}
+			if !stringsEqual(got, tt.want) {
+				t.Errorf("Find() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// records returned are copies
+	d, err := repo.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	d.Action = DEPLOY_ACTION_REMOVE
+	if d, _ = repo.Get(ctx, "1"); d.Action != DEPLOY_ACTION_CREATE {
+		t.Errorf("stored deployment is changed by caller, action = %s", d.Action)
+	}
+
+	if _, err = repo.Get(ctx, "5"); err != ErrDeploymentNotFound {
+		t.Errorf("Get() of missing deployment error = %v, want %v", err, ErrDeploymentNotFound)
+	}
+}
+
+func TestMemoryUserRepository(t *testing.T) {
+	var (
+		ctx   = context.TODO()
+		repo  = NewMemoryUserRepository()
+		alice = newUser("alice", "hash", ROLE_MEMBER, nil)
+	)
+
+	if err := repo.Insert(ctx, alice); err != nil {
+		t.Fatalf("Insert() error: %v", err)
+	}
+	if err := repo.Insert(ctx, newUser("alice", "hash", ROLE_ADMIN, nil)); err != ErrUserExist {
+		t.Errorf("Insert() of existing user error = %v, want %v", err, ErrUserExist)
+	}
+	if err := repo.Insert(ctx, newUser("bob", "hash", ROLE_VIEWER, nil)); err != nil {
+		t.Fatalf("Insert() error: %v", err)
+	}
+
+	if count, _ := repo.Count(ctx); count != 2 {
+		t.Errorf("Count() = %d, want 2", count)
+	}
+	if users, _ := repo.List(ctx); len(users) != 2 || users[0].Username != "alice" || users[1].Username != "bob" {
+		t.Errorf("List() = %v, want alice and bob", users)
+	}
+	if _, err := repo.Get(ctx, "carol"); err != ErrUserNotFound {
+		t.Errorf("Get() of missing user error = %v, want %v", err, ErrUserNotFound)
+	}
+
+	// user with other id is a different user of the same name
+	other := newUser("alice", "hash", ROLE_ADMIN, nil)
+	if err := repo.Update(ctx, other); err != ErrUserNotFound {
+		t.Errorf("Update() of other user error = %v, want %v", err, ErrUserNotFound)
+	}
+	alice.Role = ROLE_ADMIN
+	if err := repo.Update(ctx, alice); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if u, _ := repo.Get(ctx, "alice"); u.Role != ROLE_ADMIN {
+		t.Errorf("role after Update() = %s, want %s", u.Role, ROLE_ADMIN)
+	}
+
+	tokens := []*ApiToken{
+		{Id: "t1", Username: "alice", Hash: "h1"},
+		{Id: "t2", Username: "bob", Hash: "h2"},
+		{Id: "t3", Username: "alice", Hash: "h3"},
+	}
+	for _, token := range tokens {
+		if err := repo.InsertToken(ctx, token); err != nil {
+			t.Fatalf("InsertToken() error: %v", err)
+		}
+	}
+	if token, err := repo.GetTokenByHash(ctx, "h2"); err != nil || token.Id != "t2" {
+		t.Errorf("GetTokenByHash() = %v, %v, want t2", token, err)
+	}
+	if found, _ := repo.FindTokens(ctx, "alice"); len(found) != 2 || found[0].Id != "t3" || found[1].Id != "t1" {
+		t.Errorf("FindTokens() = %v, want t3 and t1", found)
+	}
+	if err := repo.DeleteToken(ctx, "bob", "t1"); err != ErrApiTokenNotFound {
+		t.Errorf("DeleteToken() of token of other user error = %v, want %v", err, ErrApiTokenNotFound)
+	}
+	if err := repo.DeleteToken(ctx, "alice", "t1"); err != nil {
+		t.Errorf("DeleteToken() error: %v", err)
+	}
+	if _, err := repo.GetTokenByHash(ctx, "h1"); err != ErrApiTokenNotFound {
+		t.Errorf("GetTokenByHash() of deleted token error = %v, want %v", err, ErrApiTokenNotFound)
+	}
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,11 +1,8 @@
 package apps
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"fmt"
-	"github.com/docker/docker/api"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/strslice"
@@ -15,7 +12,6 @@ import (
 	"iCloud/commons"
 	"iCloud/log"
 	"iCloud/rpcServer"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -23,23 +19,35 @@ import (
 
 const RemoteDockerPort = ":7777"
 
-var (
-	DockerApiCliMap map[string]*client.Client
-)
-
 type ContainerConfiguration struct {
-	ContainerName string   `json:"container_name"`
-	SourceDir     []string `json:"source_dir"`
-	ContainerPort []string `json:"container_port"`
-	HostPort      []string `json:"host_port"`
-	ImageName     string   `json:"container_image"`
-	MaxCpu        string   `json:"maxCpu"`
-	MaxMem        string   `json:"maxMem"`
-	Commands      []string `json:"commands"`
-	Pwd           string   `json:"workingDir"`
-	ClientIp      string   `json:"clientIp"`
-	RpcPort       string   `json:"rpcPort"`
-	Gpus          string   `json:"gpus"`
+	ContainerName   string            `json:"container_name"`
+	SourceDir       []string          `json:"source_dir"`
+	ContainerPort   []string          `json:"container_port"`
+	HostPort        []string          `json:"host_port"`
+	ImageName       string            `json:"container_image"`
+	MaxCpu          string            `json:"maxCpu"`
+	MaxMem          string            `json:"maxMem"`
+	Commands        []string          `json:"commands"`
+	Entrypoint      []string          `json:"entrypoint"` // entry point with args kept as they are, it can not be used with commands
+	Pwd             string            `json:"workingDir"`
+	ClientIp        string            `json:"clientIp"`
+	RpcPort         string            `json:"rpcPort"`
+	Gpus            string            `json:"gpus"`
+	AutoPull        bool              `json:"autoPull"` // pull image if it is not on host
+	Project         string            `json:"project"`  // project of container, it is label of container
+	Owner           string            `json:"owner"`    // it is set to caller when container is created
+	Env             map[string]string `json:"env"`
+	Labels          map[string]string `json:"labels"`          // labels with prefix "iCloud." are kept by iCloud
+	Secrets         []SecretRef       `json:"secrets"`         // secrets injected as env when container is created
+	RestartPolicy   string            `json:"restartPolicy"`   // no, always, unless-stopped or on-failure, default is no
+	RestartMaxRetry int               `json:"restartMaxRetry"` // max retries of on-failure, 0 is unlimited
+	HealthCheck     *HealthCheck      `json:"healthCheck"`
+	Reschedule      bool              `json:"reschedule"`     // container is created on other host by supervisor when its host is offline
+	Links           []string          `json:"links"`          // "container:alias", linked containers must be on the same host
+	Network         string            `json:"network"`        // network container is attached to, default bridge if it is not set
+	NetworkAliases  []string          `json:"networkAliases"` // names of container in user-defined network
+	// credentials of registry used by auto pull, it is never stored
+	RegistryAuth *types.AuthConfig `json:"registryAuth,omitempty" bson:"-"`
 }
 
 func (conf *ContainerConfiguration) confCheck() (err error) {
@@ -65,6 +73,11 @@ func (conf *ContainerConfiguration) confCheck() (err error) {
 		}
 	}
 
+	if len(conf.Commands) > 0 && len(conf.Entrypoint) > 0 {
+		err = errors.New("commands and entrypoint can not be used together")
+		return
+	}
+
 	if len(conf.Commands) > 0 {
 		for i := 0; i < len(conf.Commands); i++ {
 			if conf.Commands[i] == "" {
@@ -79,99 +92,81 @@ func (conf *ContainerConfiguration) confCheck() (err error) {
 		return
 	}
 
-	if c, err1 := strconv.ParseFloat(conf.MaxCpu, 64); err1 != nil {
-		log.Logger.Errorf("MaxCpu from string to int error: %v", err1)
-		err = errors.New("type of MaxCpu is not number")
-		return
-	} else {
-		if c <= 0 {
-			err = errors.New("MaxCpu is not bigger than 0")
+	envs := make(map[string]bool)
+	for k := range conf.Env {
+		if k == "" || strings.Contains(k, "=") {
+			err = errors.New("name of env can not be null or contain \"=\"")
 			return
 		}
+		envs[k] = true
 	}
 
-	if c, err1 := strconv.ParseFloat(conf.MaxMem, 64); err1 != nil {
-		log.Logger.Errorf("MaxMem from string to int error: %v", err1)
-		err = errors.New("type of MaxMem is not number")
-		return
-	} else {
-		if c <= 0 {
-			err = errors.New("MaxMem is not bigger than 0")
+	for _, ref := range conf.Secrets {
+		if ref.Name == "" || ref.Env == "" || strings.ContainsAny(ref.Env, "=,") {
+			err = errors.New("name of secret and its env are required, and env can not contain \"=\" or \",\"")
 			return
 		}
+		if envs[ref.Env] {
+			err = errors.New("env " + ref.Env + " is set more than once")
+			return
+		}
+		envs[ref.Env] = true
 	}
 
-	return
-}
+	for k := range conf.Labels {
+		if k == "" || strings.HasPrefix(k, LABEL_PREFIX) {
+			err = errors.New("label can not be null or start with " + LABEL_PREFIX)
+			return
+		}
+	}
 
-func DockerApiCliMapInit() {
-	DockerApiCliMap = make(map[string]*client.Client)
-}
+	for _, link := range conf.Links {
+		if parts := strings.Split(link, ":"); link == "" || len(parts) > 2 || parts[0] == "" {
+			err = errors.New("link must be \"container\" or \"container:alias\"")
+			return
+		}
+	}
 
-func DockerApiCliPoolAdd(ip, port string) (err error) {
-	var (
-		cli *client.Client
-		m   = "apps.docker.DockerApiCliAdd()"
-	)
-	if _, exist := DockerApiCliMap[ip]; exist {
+	if len(conf.NetworkAliases) > 0 && (conf.Network == "" || predefinedNetworks[conf.Network]) {
+		err = errors.New("aliases of container can be used only in user-defined network")
 		return
 	}
 
-	// host: it need remote docker server open remote API, edit configuration "/usr/lib/systemd/system/docker.service"
-	//if cli, err = client.NewClient("tcp://"+ip+":"+port, client.DefaultVersion, nil, nil); err != nil {
-	//	log.Logger.Errorf("%s error, create docker api client to %s:%s error: %v", m, ip, port, err)
-	//	return
-	//}
-	if cli, err = client.NewClientWithOpts(
-		client.WithVersion(api.DefaultVersion),
-		client.WithHost("tcp://"+ip+":"+port),
-	); err != nil {
-		log.Logger.Errorf("%s error, create docker api client to %s:%s error: %v", m, ip, port, err)
+	if err = restartPolicyCheck(conf); err != nil {
 		return
 	}
 
-	DockerApiCliMap[ip] = cli
-	return
-}
+	if conf.HealthCheck != nil {
+		if err = conf.HealthCheck.check(); err != nil {
+			return
+		}
+	}
 
-func DockerApiCliMapDelete(ip string) {
-	if _, exist := DockerApiCliMap[ip]; exist {
-		DockerApiCliMap[ip].Close()
-		delete(DockerApiCliMap, ip)
+	if c, err1 := strconv.ParseFloat(conf.MaxCpu, 64); err1 != nil {
+		log.Logger.Errorf("MaxCpu from string to int error: %v", err1)
+		err = errors.New("type of MaxCpu is not number")
+		return
+	} else {
+		if c <= 0 {
+			err = errors.New("MaxCpu is not bigger than 0")
+			return
+		}
 	}
-}
 
-func DockerApiCliMapReconnect(ip, port string) (err error) {
-	var (
-		m   = "apps.docker.DockerApiCliMapReconnect()"
-		cli *client.Client
-	)
-	if _, exist := DockerApiCliMap[ip]; exist {
-		DockerApiCliMap[ip].Close()
-	}
-
-	//if cli, err = client.NewClient("tcp://"+ip, client.DefaultVersion, nil, nil); err != nil {
-	//	log.Logger.Errorf("%s error, create docker api client to %s error: %v", m, ip, err)
-	//	return
-	//}
-	if cli, err = client.NewClientWithOpts(
-		client.WithVersion(api.DefaultVersion),
-		client.WithHost("tcp://"+ip+":"+port),
-	); err != nil {
-		log.Logger.Errorf("%s error, create docker api client to %s:%s error: %v", m, ip, port, err)
+	if c, err1 := strconv.ParseFloat(conf.MaxMem, 64); err1 != nil {
+		log.Logger.Errorf("MaxMem from string to int error: %v", err1)
+		err = errors.New("type of MaxMem is not number")
 		return
+	} else {
+		if c <= 0 {
+			err = errors.New("MaxMem is not bigger than 0")
+			return
+		}
 	}
 
-	DockerApiCliMap[ip] = cli
 	return
 }
 
-func DockerApiCliPoolClose() {
-	for _, cli := range DockerApiCliMap {
-		cli.Close()
-	}
-}
-
 func containerEntryPointInit(clientIp, rpcPort, pwd string, cmds strslice.StrSlice) (entryPoints strslice.StrSlice, err error) {
 	if len(cmds) == 1 {
 		entryPoints = strings.Split(cmds[0], " ")
@@ -194,7 +189,31 @@ func containerConfInit(conf *ContainerConfiguration) (containerConf *container.C
 		m                = "apps.docker.containerConfInit()"
 	)
 
-	containerConf = &container.Config{Image: conf.ImageName, WorkingDir: conf.Pwd}
+	containerConf = &container.Config{
+		Image:      conf.ImageName,
+		WorkingDir: conf.Pwd,
+		Labels:     make(map[string]string),
+	}
+
+	for k, v := range conf.Labels {
+		containerConf.Labels[k] = v
+	}
+	containerConf.Labels[LABEL_OWNER], containerConf.Labels[LABEL_PROJECT] = conf.Owner, conf.Project
+	if len(conf.Secrets) > 0 {
+		containerConf.Labels[LABEL_SECRETS] = secretLabel(conf.Secrets)
+	}
+	containerConf.Labels[LABEL_RESTART_POLICY] = restartPolicyOf(conf).Name
+	containerConf.Labels[LABEL_RESCHEDULE] = strconv.FormatBool(conf.Reschedule)
+
+	if conf.HealthCheck != nil {
+		containerConf.Healthcheck = conf.HealthCheck.config()
+	}
+
+	// env of secrets is decrypted here, so it is never stored in deployment
+	if containerConf.Env, err = containerEnv(conf); err != nil {
+		log.Logger.Errorf("%s error, env of container error: %v", m, err)
+		return
+	}
 
 	// ports container exported
 	if len(conf.ContainerPort) > 0 {
@@ -214,6 +233,8 @@ func containerConfInit(conf *ContainerConfiguration) (containerConf *container.C
 		if containerConf.Entrypoint, err = containerEntryPointInit(conf.ClientIp, conf.RpcPort, conf.Pwd, conf.Commands); err != nil {
 			return
 		}
+	} else if len(conf.Entrypoint) > 0 {
+		containerConf.Entrypoint = conf.Entrypoint
 	}
 
 	return containerConf, nil
@@ -260,8 +281,14 @@ func hostConfInit(conf *ContainerConfiguration) (hostConf *container.HostConfig,
 	}
 
 	hostConf = &container.HostConfig{
-		Binds:     mountConf,
-		Resources: *resourceConf,
+		Binds:         mountConf,
+		Resources:     *resourceConf,
+		RestartPolicy: restartPolicyOf(conf),
+		Links:         conf.Links,
+	}
+
+	if conf.Network != "" {
+		hostConf.NetworkMode = container.NetworkMode(conf.Network)
 	}
 
 	if len(bindPortMap) > 0 {
@@ -331,11 +358,22 @@ func createContainer(cli *client.Client, conf *ContainerConfiguration) (containe
 		return
 	}
 
-	fmt.Println(hostConfig)
-	fmt.Println(configObj)
+	if err = containerNetworkCheck(cli, conf); err != nil {
+		return
+	}
+
+	if conf.AutoPull {
+		if err = imageEnsure(cli, conf.ImageName, conf.RegistryAuth); err != nil {
+			return
+		}
+	}
 
-	if _container, err = cli.ContainerCreate(context.Background(), configObj, hostConfig, nil, conf.ContainerName); err != nil {
+	if _container, err = cli.ContainerCreate(context.Background(), configObj, hostConfig, networkConfInit(conf), conf.ContainerName); err != nil {
 		log.Logger.Errorf("%s error, create container error: %v", m, err)
+		if client.IsErrNotFound(err) {
+			err = errors.New("create container error, image " + conf.ImageName + " is not found on host, pull it or set autoPull")
+			return
+		}
 		err = errors.New("create container error")
 		return
 	}
@@ -393,7 +431,7 @@ func ContainerList(ctx *gin.Context) {
 		err        error
 		containers []types.Container
 		m          = "apps.docker.ContainerList()"
-		exist      bool
+		cli        *client.Client
 	)
 	hostIp, remotePort := ctx.Query("ip"), ctx.Query("port")
 	if hostIp == "" || remotePort == "" {
@@ -401,20 +439,18 @@ func ContainerList(ctx *gin.Context) {
 		goto RESPONSE
 	}
 
-	if _, exist = DockerApiCliMap[hostIp]; !exist {
-		if err = DockerApiCliPoolAdd(hostIp, remotePort); err != nil {
-			rsp["ErrorCode"], rsp["Data"] = 1, "create connection to docker api on "+hostIp+":"+remotePort+" error"+err.Error()
-			goto RESPONSE
-		}
+	if cli, err = DockerClientPool.Get(hostIp, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "create connection to docker api on "+hostIp+":"+remotePort+" error"+err.Error()
+		goto RESPONSE
 	}
 
-	if containers, err = DockerApiCliMap[hostIp].ContainerList(context.Background(), types.ContainerListOptions{All: true}); err != nil {
+	if containers, err = cli.ContainerList(context.Background(), types.ContainerListOptions{All: true}); err != nil {
 		log.Logger.Errorf("%s error, list all containers on host[%s] error: %v", m, hostIp, err)
 		rsp["ErrorCode"], rsp["Data"] = 1, "list containers error"
 		goto RESPONSE
 	}
 
-	rsp["ErrorCode"], rsp["Data"] = 0, containers
+	rsp["ErrorCode"], rsp["Data"] = 0, containerListItems(containersVisible(ctx, containers))
 
 RESPONSE:
 	ctx.JSON(http.StatusOK, rsp)
@@ -424,7 +460,7 @@ func ImageList(ctx *gin.Context) {
 	var (
 		rsp    = make(gin.H)
 		err    error
-		exist  bool
+		cli    *client.Client
 		images []types.ImageSummary
 		m      = "apps.docker.ImageList()"
 	)
@@ -434,14 +470,12 @@ func ImageList(ctx *gin.Context) {
 		goto RESPONSE
 	}
 
-	if _, exist = DockerApiCliMap[hostIp]; !exist {
-		if err = DockerApiCliPoolAdd(hostIp, remotePort); err != nil {
-			rsp["ErrorCode"], rsp["Data"] = 1, "create connection to docker api on "+hostIp+":"+remotePort+" error"+err.Error()
-			goto RESPONSE
-		}
+	if cli, err = DockerClientPool.Get(hostIp, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "create connection to docker api on "+hostIp+":"+remotePort+" error"+err.Error()
+		goto RESPONSE
 	}
 
-	if images, err = DockerApiCliMap[hostIp].ImageList(context.TODO(), types.ImageListOptions{All: true}); err != nil {
+	if images, err = cli.ImageList(context.TODO(), types.ImageListOptions{All: true}); err != nil {
 		log.Logger.Errorf("%s error, get images from %s error: %v", m, hostIp, err)
 		rsp["ErrorCode"], rsp["Data"] = 1, "get all images error"
 		goto RESPONSE
@@ -460,7 +494,8 @@ func ContainerCreate(ctx *gin.Context) {
 		containerConf = new(ContainerConfiguration)
 		rsp           = make(gin.H)
 		cli           *client.Client
-		exist         bool
+		deployment    *Deployment
+		release       func()
 	)
 	hostIp, remotePort := ctx.Param("ip"), ctx.Param("port")
 	if hostIp == "" || remotePort == "" {
@@ -479,20 +514,32 @@ func ContainerCreate(ctx *gin.Context) {
 		goto RESPONSE
 	}
 
-	if cli, exist = DockerApiCliMap[hostIp]; !exist {
-		if err = DockerApiCliPoolAdd(hostIp, remotePort); err != nil {
-			rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error"
-			goto RESPONSE
-		}
-		cli, _ = DockerApiCliMap[hostIp]
+	if err = containerOwnerSet(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
 	}
 
-	if _, err = createContainer(cli, containerConf); err != nil {
+	if release, err = containerQuotaCheck(ctx, containerConf); err != nil {
 		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
 		goto RESPONSE
 	}
+	defer release()
 
-	rsp["ErrorCode"], rsp["Data"] = 0, ""
+	if cli, err = DockerClientPool.Get(hostIp, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	deployment = newDeployment(ctx, DEPLOY_ACTION_CREATE, hostIp, remotePort)
+	deployment.Configuration = containerConf
+	deployment.ContainerId, err = createContainer(cli, containerConf)
+	deployment.finish(err)
+	if err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, deployment.ContainerId
 
 RESPONSE:
 	ctx.JSON(http.StatusOK, rsp)
@@ -500,18 +547,27 @@ RESPONSE:
 
 func ContainerStart(ctx *gin.Context) {
 	var (
-		rsp   = make(gin.H)
-		cli   *client.Client
-		exist bool
-		err   error
+		rsp        = make(gin.H)
+		cli        *client.Client
+		err        error
+		deployment *Deployment
 	)
 	ip, id := ctx.Param("ip"), ctx.Param("id")
-	if cli, exist = DockerApiCliMap[ip]; !exist {
-		rsp["ErrorCode"], rsp["Data"] = 1, "ip error"
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
 		goto RESPONSE
 	}
 
-	if err = startContainer(id, cli); err != nil {
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	deployment = newDeployment(ctx, DEPLOY_ACTION_START, ip, ctx.Param("port"))
+	deployment.ContainerId = id
+	err = startContainer(id, cli)
+	deployment.finish(err)
+	if err != nil {
 		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
 		goto RESPONSE
 	}
@@ -523,18 +579,27 @@ RESPONSE:
 
 func ContainerStop(ctx *gin.Context) {
 	var (
-		rsp   = make(gin.H)
-		cli   *client.Client
-		exist bool
-		err   error
+		rsp        = make(gin.H)
+		cli        *client.Client
+		err        error
+		deployment *Deployment
 	)
 	ip, id := ctx.Param("ip"), ctx.Param("id")
-	if cli, exist = DockerApiCliMap[ip]; !exist {
-		rsp["ErrorCode"], rsp["Data"] = 1, "ip error"
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
 		goto RESPONSE
 	}
 
-	if err = stopContainer(id, cli); err != nil {
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	deployment = newDeployment(ctx, DEPLOY_ACTION_STOP, ip, ctx.Param("port"))
+	deployment.ContainerId = id
+	err = stopContainer(id, cli)
+	deployment.finish(err)
+	if err != nil {
 		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
 		goto RESPONSE
 	}
@@ -546,23 +611,27 @@ RESPONSE:
 
 func ContainerRemove(ctx *gin.Context) {
 	var (
-		rsp   = make(gin.H)
-		cli   *client.Client
-		exist bool
-		err   error
+		rsp        = make(gin.H)
+		cli        *client.Client
+		err        error
+		deployment *Deployment
 	)
 	ip, id := ctx.Param("ip"), ctx.Param("id")
-	if cli, exist = DockerApiCliMap[ip]; !exist {
-		rsp["ErrorCode"], rsp["Data"] = 1, "ip error"
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
 		goto RESPONSE
 	}
 
-	if err = stopContainer(id, cli); err != nil {
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_WRITE); err != nil {
 		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
 		goto RESPONSE
 	}
 
-	if err = removeContainer(id, cli); err != nil {
+	deployment = newDeployment(ctx, DEPLOY_ACTION_REMOVE, ip, ctx.Param("port"))
+	deployment.ContainerId = id
+	err = removeContainer(id, cli)
+	deployment.finish(err)
+	if err != nil {
 		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
 		goto RESPONSE
 	}
@@ -576,13 +645,17 @@ func ContainerDetail(ctx *gin.Context) {
 	var (
 		rsp    = make(gin.H)
 		cli    *client.Client
-		exist  bool
 		err    error
 		detail types.ContainerJSON
 	)
 	ip, id := ctx.Param("ip"), ctx.Param("id")
-	if cli, exist = DockerApiCliMap[ip]; !exist {
-		rsp["ErrorCode"], rsp["Data"] = 1, "ip error"
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_READ); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
 		goto RESPONSE
 	}
 	if detail, err = cli.ContainerInspect(context.TODO(), id); err != nil {
@@ -590,23 +663,24 @@ func ContainerDetail(ctx *gin.Context) {
 		rsp["ErrorCode"], rsp["Data"] = 1, "get container inspect error"
 		goto RESPONSE
 	}
+	containerEnvMask(&detail)
 
 	rsp["ErrorCode"], rsp["Data"] = 0, detail
 RESPONSE:
 	ctx.JSON(http.StatusOK, rsp)
 }
 
+// read log of container once, use ContainerLogStream to follow log
 func ContainerLogs(ctx *gin.Context) {
 	var (
-		rsp          = make(gin.H)
-		cli          *client.Client
-		exist        bool
-		err          error
-		containerLog io.ReadCloser
-		logOption    = new(types.ContainerLogsOptions)
-		m            = "apps.docker.ContainerLogs"
-		ip, id       = ctx.Param("ip"), ctx.Param("id")
-		buf          = new(bytes.Buffer)
+		rsp       = make(gin.H)
+		cli       *client.Client
+		err       error
+		logLines  []*LogLine
+		logOption = new(types.ContainerLogsOptions)
+		m         = "apps.docker.ContainerLogs"
+		ip, id    = ctx.Param("ip"), ctx.Param("id")
+		data      []string
 	)
 
 	if err = ctx.BindJSON(logOption); err != nil {
@@ -617,18 +691,26 @@ func ContainerLogs(ctx *gin.Context) {
 
 	logOption.Timestamps = true
 
-	if cli, exist = DockerApiCliMap[ip]; !exist {
-		rsp["ErrorCode"], rsp["Data"] = 1, "ip error"
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
 		goto RESPONSE
 	}
 
-	if containerLog, err = cli.ContainerLogs(context.TODO(), id, *logOption); err != nil {
-		log.Logger.Errorf("%s error, get container[%s] log error: %v", m, id, err)
+	if err = containerAuthorize(ctx, cli, id, PERMISSION_READ); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
 		goto RESPONSE
 	}
 
-	buf.ReadFrom(containerLog)
-	rsp["ErrorCode"], rsp["Data"] = 0, strings.Split(buf.String(), "\n")
+	if logLines, err = containerLogLines(cli, id, *logOption); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "get container log error"
+		goto RESPONSE
+	}
+
+	data = make([]string, 0, len(logLines))
+	for _, line := range logLines {
+		data = append(data, line.String())
+	}
+	rsp["ErrorCode"], rsp["Data"] = 0, data
 
 RESPONSE:
 	ctx.JSON(http.StatusOK, rsp)
@@ -0,0 +1,325 @@
+package apps
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/commons"
+	"iCloud/conf"
+	"iCloud/log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var DockerClientPool = newDockerClientPool()
+
+// client of docker remote api on one host endpoint "ip:port"
+type dockerPoolClient struct {
+	cli          *client.Client
+	ip           string
+	port         string
+	healthy      bool
+	failures     int // continuous failures of ping
+	lastPing     int64
+	lastError    string
+	createTime   int64
+	reconnectNum int
+}
+
+// status of pooled client, used for diagnostics
+type DockerClientStatus struct {
+	Endpoint     string `json:"endpoint"`
+	Ip           string `json:"ip"`
+	Port         string `json:"port"`
+	Healthy      bool   `json:"healthy"`
+	Failures     int    `json:"failures"`
+	LastPing     int64  `json:"lastPing"`
+	LastError    string `json:"lastError"`
+	CreateTime   int64  `json:"createTime"`
+	ReconnectNum int    `json:"reconnectNum"`
+}
+
+// docker api clients of hosts, it is safe for concurrent handlers
+type dockerClientPool struct {
+	mu      sync.RWMutex
+	clients map[string]*dockerPoolClient // key is endpoint "ip:port"
+}
+
+func newDockerClientPool() *dockerClientPool {
+	return &dockerClientPool{clients: make(map[string]*dockerPoolClient)}
+}
+
+func dockerEndpoint(ip, port string) string {
+	return net.JoinHostPort(ip, port)
+}
+
+// host: it need remote docker server open remote API, edit configuration "/usr/lib/systemd/system/docker.service",
+// client uses tls if tls of host is set in configuration, and docker daemon must run with "--tlsverify"
+func dockerClientNew(ip, port string) (cli *client.Client, err error) {
+	var (
+		opts      = []client.Opt{client.WithVersion(api.DefaultVersion)}
+		tlsConf   = conf.Iconf.Docker.TlsOf(ip)
+		tlsConfig *tls.Config
+	)
+
+	if tlsConf != nil {
+		if tlsConfig, err = commons.TlsClientConfig(tlsConf, ip); err != nil {
+			return
+		}
+		// https is used by client if transport has tls config, so http client must be set before host
+		opts = append(opts, client.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}))
+	}
+	opts = append(opts, client.WithHost("tcp://"+dockerEndpoint(ip, port)))
+
+	return client.NewClientWithOpts(opts...)
+}
+
+// error of ping is described clearly if it is caused by certificate
+func dockerPingError(endpoint string, err error) error {
+	if reason, ok := commons.TlsVerifyError(err); ok {
+		return fmt.Errorf("tls of docker api on %s dose not verify, %s: %v", endpoint, reason, err)
+	}
+	return err
+}
+
+// get client of host, it is created if it is not in pool.
+// only docker api of online host in registry is allowed, port of it is used if port is empty
+func (p *dockerClientPool) Get(ip, port string) (cli *client.Client, err error) {
+	var (
+		m        = "apps.dockerPool.Get()"
+		endpoint string
+		pc       *dockerPoolClient
+		host     *commons.Host
+		exist    bool
+	)
+
+	if host, exist = HostRegistry.ByIp(ip); !exist {
+		return nil, fmt.Errorf("host %s is not online", ip)
+	}
+	if port == "" {
+		port = host.ApiPort
+	} else if port != host.ApiPort {
+		return nil, fmt.Errorf("port %s is not docker api of host %s", port, ip)
+	}
+	endpoint = dockerEndpoint(ip, port)
+
+	p.mu.RLock()
+	pc, exist = p.clients[endpoint]
+	p.mu.RUnlock()
+	if exist {
+		return pc.cli, nil
+	}
+
+	if cli, err = dockerClientNew(ip, port); err != nil {
+		log.Logger.Errorf("%s error, create docker api client to %s error: %v", m, endpoint, err)
+		return
+	}
+
+	// certificate is verified once client is created, so handler gets clear error of it.
+	// other error of ping is ignored, host may be restarting and client is checked by Watch
+	if conf.Iconf.Docker.TlsOf(ip) != nil {
+		pingCtx, pingCancel := context.WithTimeout(context.TODO(), commons.DOCKER_PING_TIMEOUT)
+		_, pingErr := cli.Ping(pingCtx)
+		pingCancel()
+		if _, ok := commons.TlsVerifyError(pingErr); ok {
+			err = dockerPingError(endpoint, pingErr)
+			log.Logger.Errorf("%s error, %v", m, err)
+			cli.Close()
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// client may be created by other handler while lock is released
+	if pc, exist = p.clients[endpoint]; exist {
+		cli.Close()
+		return pc.cli, nil
+	}
+
+	p.clients[endpoint] = &dockerPoolClient{
+		cli:        cli,
+		ip:         ip,
+		port:       port,
+		healthy:    true,
+		createTime: time.Now().Unix(),
+	}
+	return
+}
+
+// remove client of endpoint and close it
+func (p *dockerClientPool) Remove(ip, port string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remove(dockerEndpoint(ip, port))
+}
+
+// remove all clients of host
+func (p *dockerClientPool) RemoveByIp(ip string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for endpoint, pc := range p.clients {
+		if pc.ip == ip {
+			p.remove(endpoint)
+		}
+	}
+}
+
+func (p *dockerClientPool) remove(endpoint string) {
+	if pc, exist := p.clients[endpoint]; exist {
+		pc.cli.Close()
+		delete(p.clients, endpoint)
+	}
+}
+
+func (p *dockerClientPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for endpoint := range p.clients {
+		p.remove(endpoint)
+	}
+}
+
+func (p *dockerClientPool) Status() (status []*DockerClientStatus) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	status = make([]*DockerClientStatus, 0, len(p.clients))
+	for endpoint, pc := range p.clients {
+		status = append(status, &DockerClientStatus{
+			Endpoint:     endpoint,
+			Ip:           pc.ip,
+			Port:         pc.port,
+			Healthy:      pc.healthy,
+			Failures:     pc.failures,
+			LastPing:     pc.lastPing,
+			LastError:    pc.lastError,
+			CreateTime:   pc.createTime,
+			ReconnectNum: pc.reconnectNum,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Endpoint < status[j].Endpoint })
+	return
+}
+
+// ping all clients concurrently, client which fails is reconnected,
+// client which is not in host registry is evicted at once, other one is evicted after DOCKER_PING_MAX_FAILURES continuous failures
+func (p *dockerClientPool) healthCheck(ctx context.Context) {
+	var (
+		wg      = sync.WaitGroup{}
+		clients = make(map[string]*client.Client)
+		results = make(map[string]error)
+		mu      = sync.Mutex{}
+	)
+
+	p.mu.Lock()
+	for endpoint, pc := range p.clients {
+		// host is removed from registry or port of docker api is changed
+		if host, exist := HostRegistry.ByIp(pc.ip); !exist || host.ApiPort != pc.port {
+			log.Logger.Infof("docker api client to %s is evicted from pool, it is not in host registry", endpoint)
+			p.remove(endpoint)
+			continue
+		}
+		clients[endpoint] = pc.cli
+	}
+	p.mu.Unlock()
+
+	for endpoint, cli := range clients {
+		wg.Add(1)
+		go func(endpoint string, cli *client.Client) {
+			defer wg.Done()
+			pingCtx, pingCancel := context.WithTimeout(ctx, commons.DOCKER_PING_TIMEOUT)
+			defer pingCancel()
+			_, err := cli.Ping(pingCtx)
+			mu.Lock()
+			results[endpoint] = err
+			mu.Unlock()
+		}(endpoint, cli)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for endpoint, err := range results {
+		pc, exist := p.clients[endpoint]
+		// client is removed or replaced during ping
+		if !exist || pc.cli != clients[endpoint] {
+			continue
+		}
+		p.pingResult(endpoint, pc, err)
+	}
+}
+
+func (p *dockerClientPool) pingResult(endpoint string, pc *dockerPoolClient, err error) {
+	var (
+		m   = "apps.dockerPool.pingResult()"
+		cli *client.Client
+	)
+
+	pc.lastPing = time.Now().Unix()
+	if err == nil {
+		if !pc.healthy {
+			log.Logger.Infof("docker api client to %s is healthy again", endpoint)
+		}
+		pc.healthy, pc.failures, pc.lastError = true, 0, ""
+		return
+	}
+
+	err = dockerPingError(endpoint, err)
+	pc.healthy, pc.failures, pc.lastError = false, pc.failures+1, err.Error()
+	log.Logger.Errorf("%s error, ping docker api of %s error, %d continuous failures: %v", m, endpoint, pc.failures, err)
+
+	if pc.failures >= commons.DOCKER_PING_MAX_FAILURES {
+		log.Logger.Infof("docker api client to %s is evicted from pool", endpoint)
+		p.remove(endpoint)
+		return
+	}
+
+	if cli, err = dockerClientNew(pc.ip, pc.port); err != nil {
+		log.Logger.Errorf("%s error, reconnect docker api of %s error: %v", m, endpoint, err)
+		return
+	}
+	pc.cli.Close()
+	pc.cli, pc.reconnectNum = cli, pc.reconnectNum+1
+}
+
+// check health of clients every DOCKER_PING_INTERVAL, and evict clients of hosts which are offline
+func (p *dockerClientPool) Watch(ctx context.Context) {
+	var (
+		ticker              = time.NewTicker(commons.DOCKER_PING_INTERVAL)
+		events, unsubscribe = HostEventSubscribe()
+	)
+	defer ticker.Stop()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.healthCheck(ctx)
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Type == HOST_EVENT_OFFLINE {
+				p.RemoveByIp(event.Ip)
+			}
+		}
+	}
+}
+
+// status of docker api clients in pool
+func DockerClientPoolStatus(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"ErrorCode": 0, "Data": DockerClientPool.Status()})
+}
@@ -0,0 +1,153 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/coreos/etcd/clientv3"
+	"iCloud/commons"
+	"iCloud/log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// hosts registered in etcd, loaded once and kept current by HostWatch
+var HostRegistry = newHostRegistry()
+
+type hostRegistry struct {
+	mu       sync.RWMutex
+	hosts    map[string]*commons.Host // ip: host
+	revision int64                    // etcd revision which hosts are current to
+}
+
+func newHostRegistry() *hostRegistry {
+	return &hostRegistry{hosts: make(map[string]*commons.Host)}
+}
+
+// load all hosts from etcd, return hosts which are registered and deleted since last load.
+// host registered again since last load is returned as added too, its key is created after revision of last load
+func (r *hostRegistry) load(ctx context.Context) (added, removed []*commons.Host, err error) {
+	var (
+		getRsp   *clientv3.GetResponse
+		m        = "apps.hostRegistry.load()"
+		hosts    = make(map[string]*commons.Host)
+		creation = make(map[string]int64) // ip: revision which key of host is created at
+	)
+
+	if getRsp, err = commons.EtcdCli.Get(ctx, commons.ETCD_KEY_PRE, clientv3.WithPrefix()); err != nil {
+		log.Logger.Errorf("%s error, get all host from etcd error: %v", m, err)
+		return
+	}
+
+	for _, v := range getRsp.Kvs {
+		host := new(commons.Host)
+		if err = json.Unmarshal(v.Value, host); err != nil {
+			log.Logger.Errorf("%s error, %s json unmarshal error: %v", m, v.Key, err)
+			continue
+		}
+		ip := strings.TrimPrefix(string(v.Key), commons.ETCD_KEY_PRE)
+		hosts[ip], creation[ip] = host, v.CreateRevision
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added, removed = make([]*commons.Host, 0), make([]*commons.Host, 0)
+	for ip, host := range r.hosts {
+		if _, exist := hosts[ip]; !exist {
+			removed = append(removed, host)
+		}
+	}
+	for ip, host := range hosts {
+		if _, exist := r.hosts[ip]; !exist || creation[ip] > r.revision {
+			added = append(added, hostCopy(host))
+		}
+	}
+	r.hosts, r.revision = hosts, getRsp.Header.Revision
+
+	return added, removed, nil
+}
+
+func (r *hostRegistry) put(ip string, host *commons.Host, revision int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[ip] = host
+	if revision > r.revision {
+		r.revision = revision
+	}
+}
+
+func (r *hostRegistry) delete(ip string, revision int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.hosts, ip)
+	if revision > r.revision {
+		r.revision = revision
+	}
+}
+
+func (r *hostRegistry) Revision() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.revision
+}
+
+// hosts are copied, caller can modify them
+func (r *hostRegistry) filter(match func(host *commons.Host) bool) (hosts []*commons.Host, revision int64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	hosts = make([]*commons.Host, 0, len(r.hosts))
+	for _, host := range r.hosts {
+		if match == nil || match(host) {
+			hosts = append(hosts, hostCopy(host))
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Ip < hosts[j].Ip })
+
+	return hosts, r.revision
+}
+
+func (r *hostRegistry) List() (hosts []*commons.Host, revision int64) {
+	return r.filter(nil)
+}
+
+func (r *hostRegistry) ByIp(ip string) (host *commons.Host, exist bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if host, exist = r.hosts[ip]; exist {
+		host = hostCopy(host)
+	}
+	return
+}
+
+// labels are copied too, so host in registry is not changed by caller
+func hostCopy(host *commons.Host) *commons.Host {
+	h := *host
+	if host.Labels != nil {
+		h.Labels = make(map[string]string, len(host.Labels))
+		for k, v := range host.Labels {
+			h.Labels[k] = v
+		}
+	}
+	return &h
+}
+
+func (r *hostRegistry) ByLabel(key, value string) (hosts []*commons.Host) {
+	hosts, _ = r.filter(func(host *commons.Host) bool {
+		v, exist := host.Labels[key]
+		return exist && (value == "" || v == value)
+	})
+	return
+}
+
+// hosts whose free memory(GB) and cpu cores are not less than required
+func (r *hostRegistry) ByFreeResources(cpu, mem float64) (hosts []*commons.Host) {
+	hosts, _ = r.filter(func(host *commons.Host) bool {
+		freeMem, err := strconv.ParseFloat(host.FreeMem, 64)
+		return err == nil && freeMem >= mem && float64(host.CpuCores) >= cpu
+	})
+	return
+}
@@ -0,0 +1,152 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"iCloud/commons"
+	"iCloud/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HOST_EVENT_ONLINE  = "online"
+	HOST_EVENT_OFFLINE = "offline"
+)
+
+type HostEvent struct {
+	Type string        `json:"type"`
+	Ip   string        `json:"ip"`
+	Host *commons.Host `json:"host"` // last host info registered
+	Time int64         `json:"time"`
+}
+
+var (
+	hostEventSubscribers   = make(map[chan *HostEvent]struct{})
+	hostEventSubscribersMu sync.Mutex
+)
+
+// subscribe online and offline event of hosts, call cancel to stop subscribing
+func HostEventSubscribe() (ch <-chan *HostEvent, cancel func()) {
+	c := make(chan *HostEvent, 16)
+
+	hostEventSubscribersMu.Lock()
+	hostEventSubscribers[c] = struct{}{}
+	hostEventSubscribersMu.Unlock()
+
+	cancel = func() {
+		hostEventSubscribersMu.Lock()
+		defer hostEventSubscribersMu.Unlock()
+		if _, exist := hostEventSubscribers[c]; exist {
+			delete(hostEventSubscribers, c)
+			close(c)
+		}
+	}
+	return c, cancel
+}
+
+func hostEventPublish(event *HostEvent) {
+	hostEventSubscribersMu.Lock()
+	defer hostEventSubscribersMu.Unlock()
+
+	for c := range hostEventSubscribers {
+		select {
+		case c <- event:
+		default:
+			log.Logger.Warnf("apps.hostWatcher.hostEventPublish() warning, subscriber is busy, %s event of %s is dropped", event.Type, event.Ip)
+		}
+	}
+}
+
+// load hosts to HostRegistry and keep it current by watching etcd,
+// key of host is deleted when lease of host is expired
+func HostWatch(ctx context.Context) {
+	var (
+		m       = "apps.hostWatcher.HostWatch()"
+		watchCh clientv3.WatchChan
+		added   []*commons.Host
+		removed []*commons.Host
+		err     error
+	)
+
+	for {
+		loadCtx, loadCancel := context.WithTimeout(ctx, time.Second*2)
+		added, removed, err = HostRegistry.load(loadCtx)
+		loadCancel()
+		if err != nil {
+			goto RETRY
+		}
+
+		// hosts deleted and registered while watch is broken
+		for _, host := range removed {
+			log.Logger.Infof("host %s is %s", host.Ip, HOST_EVENT_OFFLINE)
+			hostEventPublish(&HostEvent{Type: HOST_EVENT_OFFLINE, Ip: host.Ip, Host: host, Time: time.Now().Unix()})
+		}
+		for _, host := range added {
+			log.Logger.Infof("host %s is %s", host.Ip, HOST_EVENT_ONLINE)
+			hostEventPublish(&HostEvent{Type: HOST_EVENT_ONLINE, Ip: host.Ip, Host: host, Time: time.Now().Unix()})
+		}
+
+		watchCh = commons.EtcdCli.Watch(
+			clientv3.WithRequireLeader(ctx),
+			commons.ETCD_KEY_PRE,
+			clientv3.WithPrefix(),
+			clientv3.WithPrevKV(),
+			clientv3.WithRev(HostRegistry.Revision()+1),
+		)
+		for watchRsp := range watchCh {
+			if err = watchRsp.Err(); err != nil {
+				log.Logger.Errorf("%s error, watch hosts error: %v", m, err)
+				break
+			}
+			for _, event := range watchRsp.Events {
+				hostWatchEventHandler(event)
+			}
+		}
+
+	RETRY:
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+			log.Logger.Warnf("%s warning, watch of hosts is broken, load and watch again", m)
+		}
+	}
+}
+
+func hostWatchEventHandler(event *clientv3.Event) {
+	var (
+		m         = "apps.hostWatcher.hostWatchEventHandler()"
+		hostEvent = &HostEvent{Time: time.Now().Unix()}
+		ip        = strings.TrimPrefix(string(event.Kv.Key), commons.ETCD_KEY_PRE)
+		host      = new(commons.Host)
+	)
+	hostEvent.Ip = ip
+
+	if event.Type == mvccpb.DELETE {
+		HostRegistry.delete(ip, event.Kv.ModRevision)
+		hostEvent.Type = HOST_EVENT_OFFLINE
+		if event.PrevKv != nil {
+			if err := json.Unmarshal(event.PrevKv.Value, host); err == nil {
+				hostEvent.Host = host
+			}
+		}
+	} else {
+		if err := json.Unmarshal(event.Kv.Value, host); err != nil {
+			log.Logger.Errorf("%s error, %s json unmarshal error: %v", m, event.Kv.Key, err)
+			return
+		}
+		HostRegistry.put(ip, host, event.Kv.ModRevision)
+		if !event.IsCreate() {
+			// heartbeat of host
+			return
+		}
+		hostEvent.Type, hostEvent.Host = HOST_EVENT_ONLINE, host
+	}
+
+	log.Logger.Infof("host %s is %s", hostEvent.Ip, hostEvent.Type)
+	hostEventPublish(hostEvent)
+}
@@ -0,0 +1,359 @@
+package apps
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/log"
+	"io"
+	"net/http"
+)
+
+// message in json stream of image pull and build
+type DockerJSONMessage struct {
+	Stream         string          `json:"stream,omitempty"`
+	Status         string          `json:"status,omitempty"`
+	Id             string          `json:"id,omitempty"`
+	Progress       string          `json:"progress,omitempty"`
+	ProgressDetail *ProgressDetail `json:"progressDetail,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Aux            json.RawMessage `json:"aux,omitempty"`
+}
+
+type ProgressDetail struct {
+	Current int64 `json:"current,omitempty"`
+	Total   int64 `json:"total,omitempty"`
+}
+
+type ImagePullRequest struct {
+	Image        string            `json:"image"` // name:tag
+	RegistryAuth *types.AuthConfig `json:"registryAuth"`
+}
+
+func encodeRegistryAuth(auth *types.AuthConfig) (encoded string, err error) {
+	var (
+		buf []byte
+	)
+	if auth == nil {
+		return "", nil
+	}
+	if buf, err = json.Marshal(auth); err != nil {
+		return
+	}
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+// read json stream of docker and call handler with each message,
+// error in message is returned as error after handler is called
+func dockerJSONStreamRead(r io.Reader, handler func(msg *DockerJSONMessage) error) (err error) {
+	var (
+		decoder = json.NewDecoder(r)
+	)
+
+	for {
+		msg := new(DockerJSONMessage)
+		if err = decoder.Decode(msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return
+		}
+		if err = handler(msg); err != nil {
+			return
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+func imagePull(ctx context.Context, cli *client.Client, image string, auth *types.AuthConfig) (progress io.ReadCloser, err error) {
+	var (
+		m            = "apps.image.imagePull()"
+		registryAuth string
+	)
+
+	if registryAuth, err = encodeRegistryAuth(auth); err != nil {
+		log.Logger.Errorf("%s error, encode registry auth error: %v", m, err)
+		return
+	}
+
+	if progress, err = cli.ImagePull(ctx, image, types.ImagePullOptions{RegistryAuth: registryAuth}); err != nil {
+		log.Logger.Errorf("%s error, pull image %s error: %v", m, image, err)
+	}
+	return
+}
+
+// pull image if it is not on the host, and wait until pull is finished
+func imageEnsure(cli *client.Client, image string, auth *types.AuthConfig) (err error) {
+	var (
+		m        = "apps.image.imageEnsure()"
+		progress io.ReadCloser
+	)
+
+	if _, _, err = cli.ImageInspectWithRaw(context.TODO(), image); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		log.Logger.Errorf("%s error, inspect image %s error: %v", m, image, err)
+		return errors.New("inspect image error")
+	}
+
+	log.Logger.Infof("image %s is not found on host, pull it", image)
+	if progress, err = imagePull(context.TODO(), cli, image, auth); err != nil {
+		return errors.New("pull image error")
+	}
+	defer progress.Close()
+
+	if err = dockerJSONStreamRead(progress, func(msg *DockerJSONMessage) error { return nil }); err != nil {
+		log.Logger.Errorf("%s error, pull image %s error: %v", m, image, err)
+		return errors.New("pull image error: " + err.Error())
+	}
+
+	return nil
+}
+
+// pull image on host, progress of pull is streamed by server-sent events "progress",
+// event "end" is sent when pull is finished, and event "error" when error occurs
+func ImagePull(ctx *gin.Context) {
+	var (
+		m          = "apps.image.ImagePull()"
+		ip         = ctx.Param("ip")
+		remotePort = ctx.Param("port")
+		req        = new(ImagePullRequest)
+		cli        *client.Client
+		err        error
+		progress   io.ReadCloser
+		reqCtx     = ctx.Request.Context()
+	)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	if err = ctx.BindJSON(req); err != nil || req.Image == "" {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "request data error, image is required"})
+		return
+	}
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "connect to remote docker api error: " + err.Error()})
+		return
+	}
+
+	// pull is canceled when browser disconnects
+	if progress, err = imagePull(reqCtx, cli, req.Image, req.RegistryAuth); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "pull image error"})
+		return
+	}
+	defer progress.Close()
+
+	err = dockerJSONStreamRead(progress, func(msg *DockerJSONMessage) error {
+		ctx.SSEvent("progress", msg)
+		ctx.Writer.Flush()
+		return reqCtx.Err()
+	})
+
+	switch {
+	case reqCtx.Err() != nil:
+		log.Logger.Infof("pull image %s on %s is canceled by browser", req.Image, ip)
+	case err != nil:
+		log.Logger.Errorf("%s error, pull image %s on %s error: %v", m, req.Image, ip, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "pull image error: " + err.Error()})
+	default:
+		ctx.SSEvent("end", gin.H{"ErrorCode": 0, "Data": req.Image})
+	}
+	ctx.Writer.Flush()
+}
+
+type ImageTagRequest struct {
+	Source string `json:"source"` // name or id of image
+	Target string `json:"target"` // new name:tag
+}
+
+// images and layers untagged or deleted, space reclaimed is not counted as layers may be shared with other images,
+// prune reports space reclaimed
+type ImageRemoveResult struct {
+	Items []types.ImageDeleteResponseItem `json:"items"`
+}
+
+func ImageInspect(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.image.ImageInspect()"
+		ip, image  = ctx.Param("ip"), ctx.Query("image")
+		remotePort = ctx.Param("port")
+		cli        *client.Client
+		err        error
+		detail     types.ImageInspect
+	)
+
+	if image == "" {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, image is required in url"
+		goto RESPONSE
+	}
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if detail, _, err = cli.ImageInspectWithRaw(context.TODO(), image); err != nil {
+		log.Logger.Errorf("%s error, inspect image %s on %s error: %v", m, image, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "inspect image error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, detail
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func ImageHistory(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.image.ImageHistory()"
+		ip, image  = ctx.Param("ip"), ctx.Query("image")
+		remotePort = ctx.Param("port")
+		cli        *client.Client
+		err        error
+		history    []imagetypes.HistoryResponseItem
+	)
+
+	if image == "" {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, image is required in url"
+		goto RESPONSE
+	}
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if history, err = cli.ImageHistory(context.TODO(), image); err != nil {
+		log.Logger.Errorf("%s error, get history of image %s on %s error: %v", m, image, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "get image history error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, history
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func ImageTag(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.image.ImageTag()"
+		ip         = ctx.Param("ip")
+		remotePort = ctx.Param("port")
+		req        = new(ImageTagRequest)
+		cli        *client.Client
+		err        error
+	)
+
+	if err = ctx.BindJSON(req); err != nil || req.Source == "" || req.Target == "" {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, source and target are required"
+		goto RESPONSE
+	}
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = cli.ImageTag(context.TODO(), req.Source, req.Target); err != nil {
+		log.Logger.Errorf("%s error, tag image %s to %s on %s error: %v", m, req.Source, req.Target, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "tag image error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, req.Target
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// remove image, options in url: force=true, noPrune=true (untagged parents are not deleted)
+func ImageRemove(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.image.ImageRemove()"
+		ip, image  = ctx.Param("ip"), ctx.Query("image")
+		remotePort = ctx.Param("port")
+		cli        *client.Client
+		err        error
+		result     = new(ImageRemoveResult)
+		deleted    int
+	)
+
+	if image == "" {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, image is required in url"
+		goto RESPONSE
+	}
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if result.Items, err = cli.ImageRemove(context.TODO(), image, types.ImageRemoveOptions{
+		Force:         ctx.Query("force") == "true",
+		PruneChildren: ctx.Query("noPrune") != "true",
+	}); err != nil {
+		log.Logger.Errorf("%s error, remove image %s on %s error: %v", m, image, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "remove image error: "+err.Error()
+		goto RESPONSE
+	}
+
+	for _, item := range result.Items {
+		if item.Deleted != "" {
+			deleted++
+		}
+	}
+
+	log.Logger.Infof("image %s on %s is removed by %s, %d images and layers deleted", image, ip, requestCaller(ctx), deleted)
+	rsp["ErrorCode"], rsp["Data"] = 0, result
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// prune dangling images on host, all unused images are pruned if "all=true" is in url
+func ImagePrune(ctx *gin.Context) {
+	var (
+		rsp        = make(gin.H)
+		m          = "apps.image.ImagePrune()"
+		ip         = ctx.Param("ip")
+		remotePort = ctx.Param("port")
+		cli        *client.Client
+		err        error
+		report     types.ImagesPruneReport
+		dangling   = "true"
+	)
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if ctx.Query("all") == "true" {
+		dangling = "false"
+	}
+
+	if report, err = cli.ImagesPrune(context.TODO(), filters.NewArgs(filters.Arg("dangling", dangling))); err != nil {
+		log.Logger.Errorf("%s error, prune images on %s error: %v", m, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "prune images error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("images on %s are pruned by %s, %d bytes reclaimed", ip, requestCaller(ctx), report.SpaceReclaimed)
+	rsp["ErrorCode"], rsp["Data"] = 0, report
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
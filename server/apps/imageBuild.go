@@ -0,0 +1,217 @@
+package apps
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/log"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// build context is a file uploaded by webUploader, it is identified by task id and file name of upload
+type ImageBuildRequest struct {
+	TaskId     string             `json:"taskId"`
+	FileName   string             `json:"fileName"`
+	Dockerfile string             `json:"dockerfile"` // path in build context, default is "Dockerfile"
+	Tags       []string           `json:"tags"`       // name:tag
+	BuildArgs  map[string]*string `json:"buildArgs"`
+	NoCache    bool               `json:"noCache"`
+	Pull       bool               `json:"pull"` // always pull newer version of base image
+}
+
+// docker accepts tar and compressed tar as build context
+func isTarArchive(fileName string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tar.xz"} {
+		if strings.HasSuffix(strings.ToLower(fileName), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// write files in dir to tar stream, paths in tar are relative to dir
+func tarDir(dir string, w io.Writer) (err error) {
+	var (
+		tw = tar.NewWriter(w)
+	)
+
+	if err = filepath.Walk(dir, func(file string, info os.FileInfo, walkErr error) (err error) {
+		var (
+			name   string
+			link   string
+			header *tar.Header
+			f      *os.File
+		)
+
+		if walkErr != nil {
+			return walkErr
+		}
+		if name, err = filepath.Rel(dir, file); err != nil || name == "." {
+			return
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return
+			}
+		}
+		if header, err = tar.FileInfoHeader(info, link); err != nil {
+			return
+		}
+		header.Name = filepath.ToSlash(name)
+		if err = tw.WriteHeader(header); err != nil {
+			return
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if f, err = os.Open(file); err != nil {
+			return
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return
+	}); err != nil {
+		return
+	}
+	return tw.Close()
+}
+
+// open build context of uploaded file: an archive is sent as it is,
+// a dir or other file is packed to tar, so a single Dockerfile can be uploaded as build context
+func buildContextOpen(taskId, fileName string) (buildContext io.ReadCloser, err error) {
+	var (
+		m    = "apps.imageBuild.buildContextOpen()"
+		file = uploadFilePath(taskId, fileName)
+		info os.FileInfo
+		dir  string
+		pr   *io.PipeReader
+		pw   *io.PipeWriter
+	)
+
+	if info, err = os.Stat(file); err != nil {
+		log.Logger.Errorf("%s error, uploaded file %s dose not exist: %v", m, file, err)
+		return nil, errors.New("uploaded build context dose not exist")
+	}
+
+	if !info.IsDir() && isTarArchive(fileName) {
+		if buildContext, err = os.Open(file); err != nil {
+			log.Logger.Errorf("%s error, open uploaded file %s error: %v", m, file, err)
+			return nil, errors.New("open build context error")
+		}
+		return
+	}
+
+	dir = file
+	if !info.IsDir() {
+		dir = uploadDir(taskId, fileName)
+	}
+
+	pr, pw = io.Pipe()
+	go func() {
+		tarErr := tarDir(dir, pw)
+		if tarErr != nil {
+			log.Logger.Errorf("%s error, pack %s to tar error: %v", m, dir, tarErr)
+		}
+		// build fails with tarErr if it is not nil
+		pw.CloseWithError(tarErr)
+	}()
+	return pr, nil
+}
+
+// build image on host from uploaded build context, output of build is streamed by server-sent events "progress",
+// event "end" is sent with image id when build is finished, and event "error" when error occurs
+func ImageBuild(ctx *gin.Context) {
+	var (
+		m            = "apps.imageBuild.ImageBuild()"
+		ip           = ctx.Param("ip")
+		remotePort   = ctx.Param("port")
+		req          = new(ImageBuildRequest)
+		cli          *client.Client
+		err          error
+		buildContext io.ReadCloser
+		buildRsp     types.ImageBuildResponse
+		imageId      string
+		reqCtx       = ctx.Request.Context()
+	)
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	if err = ctx.BindJSON(req); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "request data error"})
+		return
+	}
+	if err = uploadNameCheck(req.TaskId, req.FileName); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": err.Error()})
+		return
+	}
+	if err = uploadAuthorize(callerUser(ctx), req.TaskId, req.FileName, PERMISSION_READ); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": err.Error()})
+		return
+	}
+	if len(req.Tags) == 0 {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "request data error, tags is required"})
+		return
+	}
+
+	if cli, err = DockerClientPool.Get(ip, remotePort); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "connect to remote docker api error: " + err.Error()})
+		return
+	}
+
+	if buildContext, err = buildContextOpen(req.TaskId, req.FileName); err != nil {
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": err.Error()})
+		return
+	}
+	defer buildContext.Close()
+
+	// build is canceled when browser disconnects
+	if buildRsp, err = cli.ImageBuild(reqCtx, buildContext, types.ImageBuildOptions{
+		Tags:        req.Tags,
+		Dockerfile:  req.Dockerfile,
+		BuildArgs:   req.BuildArgs,
+		NoCache:     req.NoCache,
+		PullParent:  req.Pull,
+		Remove:      true,
+		ForceRemove: true,
+	}); err != nil {
+		log.Logger.Errorf("%s error, build image %v on %s error: %v", m, req.Tags, ip, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "build image error"})
+		return
+	}
+	defer buildRsp.Body.Close()
+
+	err = dockerJSONStreamRead(buildRsp.Body, func(msg *DockerJSONMessage) error {
+		// id of built image is in aux message
+		if len(msg.Aux) > 0 {
+			var aux types.BuildResult
+			if json.Unmarshal(msg.Aux, &aux) == nil && aux.ID != "" {
+				imageId = aux.ID
+			}
+		}
+		ctx.SSEvent("progress", msg)
+		ctx.Writer.Flush()
+		return reqCtx.Err()
+	})
+
+	switch {
+	case reqCtx.Err() != nil:
+		log.Logger.Infof("build image %v on %s is canceled by browser", req.Tags, ip)
+	case err != nil:
+		log.Logger.Errorf("%s error, build image %v on %s error: %v", m, req.Tags, ip, err)
+		ctx.SSEvent("error", gin.H{"ErrorCode": 1, "Data": "build image error: " + err.Error()})
+	default:
+		log.Logger.Infof("image %v is built on %s by %s", req.Tags, ip, requestCaller(ctx))
+		ctx.SSEvent("end", gin.H{"ErrorCode": 0, "Data": gin.H{"id": imageId, "tags": req.Tags}})
+	}
+	ctx.Writer.Flush()
+}
@@ -0,0 +1,394 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"iCloud/commons"
+	"iCloud/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	JOB_STATUS_PENDING   = "pending" // job is in queue, it is started when a host has enough resource
+	JOB_STATUS_RUNNING   = "running"
+	JOB_STATUS_SUCCEEDED = "succeeded"
+	JOB_STATUS_FAILED    = "failed"
+	JOB_STATUS_CANCELLED = "cancelled"
+)
+
+var Jobs = NewMemoryJobRepository()
+
+// container which runs to completion, it is tracked until exit
+type Job struct {
+	Id            string                  `json:"id" bson:"_id"`
+	Name          string                  `json:"name" bson:"name"` // container name in request, name of container is "name-id"
+	Status        string                  `json:"status" bson:"status"`
+	Error         string                  `json:"error" bson:"error"`
+	Configuration *ContainerConfiguration `json:"configuration" bson:"configuration"`
+	Strategy      string                  `json:"strategy" bson:"strategy"` // placement strategy if host is not given
+	TargetIp      string                  `json:"targetIp" bson:"targetIp"` // host given by caller, job waits for it in queue
+	TargetPort    string                  `json:"targetPort" bson:"targetPort"`
+	Priority      int                     `json:"priority" bson:"priority"` // job with higher priority is started first
+	MaxRetries    int                     `json:"maxRetries" bson:"maxRetries"`
+	Attempts      int                     `json:"attempts" bson:"attempts"`       // times of starting
+	NextAttempt   int64                   `json:"nextAttempt" bson:"nextAttempt"` // job is not started before it after failure of starting
+	HostIp        string                  `json:"hostIp" bson:"hostIp"`
+	HostPort      string                  `json:"hostPort" bson:"hostPort"` // docker remote api port
+	ContainerId   string                  `json:"containerId" bson:"containerId"`
+	ExitCode      int64                   `json:"exitCode" bson:"exitCode"`
+	LogTail       []string                `json:"logTail" bson:"logTail"` // last lines of log after exit
+	Caller        string                  `json:"caller" bson:"caller"`
+	Schedule      string                  `json:"schedule" bson:"schedule"` // name of schedule which submits the job
+	CreateTime    int64                   `json:"createTime" bson:"createTime"`
+	StartTime     int64                   `json:"startTime" bson:"startTime"`
+	FinishTime    int64                   `json:"finishTime" bson:"finishTime"`
+	Duration      int64                   `json:"duration" bson:"duration"` // seconds from start to finish
+}
+
+func jobAllowed(user *User, job *Job, permission string) bool {
+	project := ""
+	if job.Configuration != nil {
+		project = job.Configuration.Project
+	}
+	return user.Allowed(job.Caller, project, permission)
+}
+
+// container of job must exit by itself
+func jobConfCheck(conf *ContainerConfiguration) error {
+	if conf.RestartPolicy != "" && conf.RestartPolicy != RESTART_POLICY_NO {
+		return errors.New("restart policy of job must be no, job is tracked until its container exits")
+	}
+	if conf.Reschedule {
+		return errors.New("job can not be rescheduled")
+	}
+	return nil
+}
+
+// pending job of configuration, same job can be submitted many times, so id is part of name of container
+func newJob(conf *ContainerConfiguration, caller string) *Job {
+	job := &Job{
+		Id:         primitive.NewObjectID().Hex(),
+		Name:       conf.ContainerName,
+		Status:     JOB_STATUS_PENDING,
+		Strategy:   PLACEMENT_BIN_PACK,
+		Caller:     caller,
+		CreateTime: time.Now().Unix(),
+	}
+	conf.ContainerName = job.Name + "-" + job.Id
+	conf.RegistryAuth = nil
+	job.Configuration = conf
+	return job
+}
+
+func jobSave(job *Job) {
+	mongoCtx, mongoCancel := context.WithTimeout(context.TODO(), commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	if err := Jobs.Update(mongoCtx, job); err != nil {
+		log.Logger.Errorf("apps.job.jobSave() error, save job[%s] with status %s error: %v", job.Id, job.Status, err)
+	}
+}
+
+// save job only if it is still in status "from", so concurrent transitions of one job, e.g. start and cancel, can not both succeed
+func jobSaveIf(job *Job, from string) error {
+	mongoCtx, mongoCancel := context.WithTimeout(context.TODO(), commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	return Jobs.UpdateIf(mongoCtx, job, from)
+}
+
+func (job *Job) fail(err error) {
+	job.Status, job.Error, job.FinishTime = JOB_STATUS_FAILED, err.Error(), time.Now().Unix()
+	if job.StartTime > 0 {
+		job.Duration = job.FinishTime - job.StartTime
+	}
+	jobSave(job)
+	log.Logger.Infof("job %s[%s] is failed: %v", job.Name, job.Id, err)
+}
+
+// check quota of caller of job, resources of job are reserved until release is called
+func jobQuotaCheck(ctx context.Context, job *Job) (release func(), err error) {
+	var (
+		m     = "apps.job.jobQuotaCheck()"
+		owner *User
+	)
+
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	if owner, err = Users.Get(mongoCtx, job.Caller); err != nil {
+		log.Logger.Errorf("%s error, get caller %s of job[%s] error: %v", m, job.Caller, job.Id, err)
+		return nil, errors.New("get caller " + job.Caller + " of job error")
+	}
+	return userQuotaCheck(mongoCtx, owner, job.Configuration)
+}
+
+// create and start container of job on host chosen by dispatcher,
+// container which fails to start is removed, so it can be created again with the same name
+func jobStart(job *Job, host *commons.Host) (cli *client.Client, err error) {
+	var (
+		m    = "apps.job.jobStart()"
+		conf = job.Configuration
+	)
+
+	job.HostIp, job.HostPort = host.Ip, host.ApiPort
+	if job.TargetPort != "" {
+		job.HostPort = job.TargetPort
+	}
+	conf.ClientIp, conf.RpcPort = host.Ip, host.GrpcPort
+
+	if cli, err = DockerClientPool.Get(job.HostIp, job.HostPort); err != nil {
+		return nil, errors.New("connect to remote docker api error: " + err.Error())
+	}
+	if job.ContainerId, err = createContainer(cli, conf); err != nil {
+		return
+	}
+	if err = startContainer(job.ContainerId, cli); err != nil {
+		if rmErr := cli.ContainerRemove(context.TODO(), job.ContainerId, types.ContainerRemoveOptions{Force: true}); rmErr != nil {
+			log.Logger.Errorf("%s error, remove container[%s] of job %s which fails to start error: %v", m, job.ContainerId, job.Id, rmErr)
+		}
+		job.ContainerId = ""
+		return
+	}
+
+	job.Status, job.StartTime = JOB_STATUS_RUNNING, time.Now().Unix()
+	jobSave(job)
+	return
+}
+
+// wait until container of job exits, connection to docker may be broken when host is restarting, so waiting is retried
+func jobWait(ctx context.Context, job *Job, cli *client.Client) (exitCode int64, err error) {
+	var (
+		m = "apps.job.jobWait()"
+	)
+
+	for retry := 0; ; retry++ {
+		resultC, errC := cli.ContainerWait(ctx, job.ContainerId, container.WaitConditionNotRunning)
+		select {
+		case result := <-resultC:
+			if result.Error != nil && result.Error.Message != "" {
+				return result.StatusCode, errors.New(result.Error.Message)
+			}
+			return result.StatusCode, nil
+		case err = <-errC:
+		}
+
+		if ctx.Err() != nil || client.IsErrNotFound(err) || retry >= commons.JOB_WAIT_MAX_RETRIES {
+			return -1, fmt.Errorf("wait container of job error: %v", err)
+		}
+		log.Logger.Errorf("%s error, wait container[%s] of job %s error, retry %d: %v", m, job.ContainerId, job.Id, retry+1, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(commons.JOB_WAIT_RETRY_INTERVAL):
+		}
+		if newCli, getErr := DockerClientPool.Get(job.HostIp, job.HostPort); getErr == nil {
+			cli = newCli
+		}
+	}
+}
+
+// record exit code, duration and last lines of log of job
+func jobFinish(job *Job, cli *client.Client, exitCode int64, err error) {
+	var (
+		m     = "apps.job.jobFinish()"
+		lines []*LogLine
+	)
+
+	if err != nil {
+		job.fail(err)
+		return
+	}
+
+	job.FinishTime, job.ExitCode = time.Now().Unix(), exitCode
+	job.Duration = job.FinishTime - job.StartTime
+	if job.Status = JOB_STATUS_SUCCEEDED; exitCode != 0 {
+		job.Status, job.Error = JOB_STATUS_FAILED, "container exits with code "+strconv.FormatInt(exitCode, 10)
+	}
+
+	logOption := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Timestamps: true, Tail: commons.JOB_LOG_TAIL}
+	if lines, err = containerLogLines(cli, job.ContainerId, logOption); err != nil {
+		log.Logger.Errorf("%s error, get log of job %s error: %v", m, job.Id, err)
+	}
+	job.LogTail = make([]string, 0, len(lines))
+	for _, line := range lines {
+		job.LogTail = append(job.LogTail, line.String())
+	}
+
+	jobSave(job)
+	log.Logger.Infof("job %s[%s] is %s, exit code %d, duration %ds", job.Name, job.Id, job.Status, exitCode, job.Duration)
+}
+
+// track job until its container exits
+func jobTrack(ctx context.Context, job *Job, cli *client.Client) {
+	exitCode, err := jobWait(ctx, job, cli)
+	// job is still running when server is closed, it is tracked again by JobRecover
+	if ctx.Err() != nil {
+		return
+	}
+	jobFinish(job, cli, exitCode, err)
+}
+
+// track running jobs again after server is restarted, pending jobs are kept in queue of JobDispatcher
+func JobRecover(ctx context.Context) {
+	var (
+		m    = "apps.job.JobRecover()"
+		jobs []*Job
+		err  error
+	)
+
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+
+	if jobs, err = Jobs.Find(mongoCtx, &JobFilter{Status: JOB_STATUS_RUNNING}); err != nil {
+		log.Logger.Errorf("%s error, find running jobs error: %v", m, err)
+		return
+	}
+	for _, job := range jobs {
+		go func(job *Job) {
+			// job is claimed as running before its container is created
+			if job.ContainerId == "" {
+				job.fail(errors.New("server is stopped when job is starting"))
+				return
+			}
+			cli, err := DockerClientPool.Get(job.HostIp, job.HostPort)
+			if err != nil {
+				job.fail(errors.New("connect to remote docker api error: " + err.Error()))
+				return
+			}
+			jobTrack(ctx, job, cli)
+		}(job)
+	}
+	log.Logger.Infof("%d running jobs are tracked again", len(jobs))
+}
+
+// submit a job to queue, it runs on host of "ip" and "port" in url, or on host chosen by scheduler with "strategy" in url.
+// "priority" and "maxRetries" of starting can be set in url
+func JobSubmit(ctx *gin.Context) {
+	var (
+		rsp           = make(gin.H)
+		m             = "apps.job.JobSubmit()"
+		containerConf = new(ContainerConfiguration)
+		job           *Job
+		priority      int
+		maxRetries    int
+		err           error
+		release       func()
+	)
+
+	if priority, err = strconv.Atoi(ctx.DefaultQuery("priority", "0")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, priority is not integer"
+		goto RESPONSE
+	}
+	if maxRetries, err = strconv.Atoi(ctx.DefaultQuery("maxRetries", strconv.Itoa(commons.JOB_DEFAULT_MAX_RETRIES))); err != nil || maxRetries < 0 {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, maxRetries is not integer or less than 0"
+		goto RESPONSE
+	}
+
+	if err = ctx.BindJSON(containerConf); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+
+	if err = containerConf.confCheck(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = jobConfCheck(containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerOwnerSet(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	// container of job is created by dispatcher later, so its resources are not reserved, quota is checked again before it is started
+	if release, err = containerQuotaCheck(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	release()
+
+	job = newJob(containerConf, requestCaller(ctx))
+	job.Strategy, job.TargetIp, job.TargetPort = ctx.DefaultQuery("strategy", PLACEMENT_BIN_PACK), ctx.Query("ip"), ctx.Query("port")
+	job.Priority, job.MaxRetries = priority, maxRetries
+	if _, exist := getPlacementStrategy(job.Strategy); !exist {
+		rsp["ErrorCode"], rsp["Data"] = 1, "placement strategy "+job.Strategy+" dose not exist"
+		goto RESPONSE
+	}
+
+	if err = Jobs.Insert(ctx, job); err != nil {
+		log.Logger.Errorf("%s error, insert job %s error: %v", m, job.Name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "save job error"
+		goto RESPONSE
+	}
+
+	JobDispatcher.Wake()
+
+	log.Logger.Infof("job %s[%s] is submitted by %s", job.Name, job.Id, job.Caller)
+	rsp["ErrorCode"], rsp["Data"] = 0, job
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func JobList(ctx *gin.Context) {
+	var (
+		rsp    = make(gin.H)
+		m      = "apps.job.JobList()"
+		filter = new(JobFilter)
+		jobs   []*Job
+		err    error
+	)
+
+	if err = ctx.ShouldBindQuery(filter); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "query param error"
+		goto RESPONSE
+	}
+	if user := callerUser(ctx); !user.IsAdmin() {
+		filter.visibleTo(user)
+	}
+
+	if jobs, err = Jobs.Find(ctx, filter); err != nil {
+		log.Logger.Errorf("%s error, find jobs error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "find jobs error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, jobs
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func JobDetail(ctx *gin.Context) {
+	var (
+		rsp = make(gin.H)
+		m   = "apps.job.JobDetail()"
+		job *Job
+		err error
+	)
+
+	if job, err = Jobs.Get(ctx, ctx.Param("id")); err != nil {
+		if err != ErrJobNotFound {
+			log.Logger.Errorf("%s error, get job[%s] error: %v", m, ctx.Param("id"), err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get job error"
+		goto RESPONSE
+	}
+	if !jobAllowed(callerUser(ctx), job, PERMISSION_READ) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, job
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,287 @@
+package apps
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"iCloud/commons"
+	"iCloud/log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var JobDispatcher = newJobDispatcher()
+
+// pending job with its position in queue
+type JobQueueItem struct {
+	Position int `json:"position"`
+	*Job
+}
+
+// start pending jobs when hosts have enough resource, queue is the pending jobs in JobRepository, so it is kept after restart
+type jobDispatcher struct {
+	mu          sync.Mutex
+	dispatching map[string]bool // jobs which are being started
+	wake        chan struct{}
+}
+
+func newJobDispatcher() *jobDispatcher {
+	return &jobDispatcher{dispatching: make(map[string]bool), wake: make(chan struct{}, 1)}
+}
+
+// dispatch jobs now, e.g. a job is submitted
+func (d *jobDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *jobDispatcher) isDispatching(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dispatching[id]
+}
+
+func (d *jobDispatcher) setDispatching(id string, dispatching bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dispatching {
+		d.dispatching[id] = true
+	} else {
+		delete(d.dispatching, id)
+	}
+}
+
+// pending jobs, higher priority first and then earlier submitted first
+func jobQueue(ctx context.Context) (jobs []*Job, err error) {
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+
+	if jobs, err = Jobs.Find(mongoCtx, &JobFilter{Status: JOB_STATUS_PENDING}); err != nil {
+		return
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Priority == jobs[j].Priority {
+			return jobs[i].CreateTime < jobs[j].CreateTime
+		}
+		return jobs[i].Priority > jobs[j].Priority
+	})
+	return
+}
+
+// start jobs in order of queue, job which can not be placed waits and the next one is tried.
+// free resource of host is updated by its heartbeat, so one host gets one job in one round
+func (d *jobDispatcher) dispatch(ctx context.Context) {
+	var (
+		m     = "apps.jobQueue.dispatch()"
+		jobs  []*Job
+		hosts []*commons.Host
+		used  = make(map[string]bool)
+		now   = time.Now().Unix()
+		err   error
+	)
+
+	if jobs, err = jobQueue(ctx); err != nil {
+		log.Logger.Errorf("%s error, get queue of jobs error: %v", m, err)
+		return
+	}
+	hosts, _ = HostRegistry.List()
+
+	for _, job := range jobs {
+		if job.NextAttempt > now || d.isDispatching(job.Id) {
+			continue
+		}
+
+		candidates := make([]*commons.Host, 0, len(hosts))
+		for _, host := range hosts {
+			if !used[host.Ip] && (job.TargetIp == "" || job.TargetIp == host.Ip) {
+				candidates = append(candidates, host)
+			}
+		}
+
+		decision, placeErr := placeContainer(candidates, job.Configuration, job.Strategy)
+		if placeErr != nil {
+			// job may be cancelled after queue is read
+			if reason := "waiting in queue: " + placeErr.Error(); job.Error != reason {
+				job.Error = reason
+				if err = jobSaveIf(job, JOB_STATUS_PENDING); err != nil && err != ErrJobStatusChanged {
+					log.Logger.Errorf("%s error, save job[%s] error: %v", m, job.Id, err)
+				}
+			}
+			continue
+		}
+
+		used[decision.Host.Ip] = true
+		d.setDispatching(job.Id, true)
+		go d.start(ctx, job, decision.Host)
+	}
+}
+
+func (d *jobDispatcher) start(ctx context.Context, job *Job, host *commons.Host) {
+	var (
+		m = "apps.jobQueue.start()"
+	)
+
+	// jobs in queue are not in usage of quota, so quota is checked again when job is started,
+	// job is kept in queue if it exceeds quota, and resources of it are reserved until its container is created
+	release, err := jobQuotaCheck(ctx, job)
+	if err != nil {
+		if reason := "waiting in queue: " + err.Error(); job.Error != reason {
+			job.Error = reason
+			if err = jobSaveIf(job, JOB_STATUS_PENDING); err != nil && err != ErrJobStatusChanged {
+				log.Logger.Errorf("%s error, save job[%s] error: %v", m, job.Id, err)
+			}
+		}
+		d.setDispatching(job.Id, false)
+		return
+	}
+
+	// job may be cancelled after queue is read, it is claimed as running so it can not be cancelled when it is starting
+	job.Status = JOB_STATUS_RUNNING
+	if err = jobSaveIf(job, JOB_STATUS_PENDING); err != nil {
+		if err != ErrJobStatusChanged {
+			log.Logger.Errorf("%s error, claim job[%s] error: %v", m, job.Id, err)
+		}
+		release()
+		d.setDispatching(job.Id, false)
+		return
+	}
+
+	job.Attempts++
+	cli, err := jobStart(job, host)
+	release()
+	d.setDispatching(job.Id, false)
+	if err != nil {
+		d.retry(job, err)
+		return
+	}
+
+	log.Logger.Infof("job %s[%s] is started on %s, attempt %d", job.Name, job.Id, job.HostIp, job.Attempts)
+	jobTrack(ctx, job, cli)
+}
+
+// job which fails to start is back to queue after backoff, it is failed after MaxRetries retries
+func (d *jobDispatcher) retry(job *Job, err error) {
+	var (
+		backoff = commons.JOB_RETRY_BACKOFF
+	)
+
+	if job.Attempts > job.MaxRetries {
+		job.fail(fmt.Errorf("start job error after %d attempts: %v", job.Attempts, err))
+		return
+	}
+
+	for i := 1; i < job.Attempts && backoff < commons.JOB_RETRY_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	if backoff > commons.JOB_RETRY_MAX_BACKOFF {
+		backoff = commons.JOB_RETRY_MAX_BACKOFF
+	}
+
+	job.Status, job.Error = JOB_STATUS_PENDING, err.Error()
+	job.NextAttempt = time.Now().Add(backoff).Unix()
+	job.HostIp, job.HostPort = "", ""
+	jobSave(job)
+	log.Logger.Infof("job %s[%s] fails to start, attempt %d, retry after %v: %v", job.Name, job.Id, job.Attempts, backoff, err)
+}
+
+// dispatch jobs every JOB_DISPATCH_INTERVAL, when job is submitted and when host is online
+func (d *jobDispatcher) Watch(ctx context.Context) {
+	var (
+		ticker              = time.NewTicker(commons.JOB_DISPATCH_INTERVAL)
+		events, unsubscribe = HostEventSubscribe()
+	)
+	defer ticker.Stop()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Type != HOST_EVENT_ONLINE {
+				continue
+			}
+		}
+		d.dispatch(ctx)
+	}
+}
+
+// pending jobs in order they are started, non-admin user gets only jobs which can be read by the user
+func JobQueueList(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		m     = "apps.jobQueue.JobQueueList()"
+		user  = callerUser(ctx)
+		jobs  []*Job
+		items = make([]*JobQueueItem, 0)
+		err   error
+	)
+
+	if jobs, err = jobQueue(ctx); err != nil {
+		log.Logger.Errorf("%s error, get queue of jobs error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "get queue of jobs error"
+		goto RESPONSE
+	}
+
+	for i, job := range jobs {
+		if jobAllowed(user, job, PERMISSION_READ) {
+			items = append(items, &JobQueueItem{Position: i + 1, Job: job})
+		}
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, items
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// cancel job in queue, job which is started can not be cancelled
+func JobCancel(ctx *gin.Context) {
+	var (
+		rsp = make(gin.H)
+		m   = "apps.jobQueue.JobCancel()"
+		job *Job
+		err error
+	)
+
+	if job, err = Jobs.Get(ctx, ctx.Param("id")); err != nil {
+		if err != ErrJobNotFound {
+			log.Logger.Errorf("%s error, get job[%s] error: %v", m, ctx.Param("id"), err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get job error"
+		goto RESPONSE
+	}
+	if !jobAllowed(callerUser(ctx), job, PERMISSION_WRITE) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+	if job.Status != JOB_STATUS_PENDING || JobDispatcher.isDispatching(job.Id) {
+		rsp["ErrorCode"], rsp["Data"] = 1, "only job in queue can be cancelled, job is "+job.Status
+		goto RESPONSE
+	}
+
+	// job may be started after it is read
+	job.Status, job.FinishTime = JOB_STATUS_CANCELLED, time.Now().Unix()
+	if err = Jobs.UpdateIf(ctx, job, JOB_STATUS_PENDING); err != nil {
+		if err == ErrJobStatusChanged {
+			rsp["ErrorCode"], rsp["Data"] = 1, "only job in queue can be cancelled, job is started"
+			goto RESPONSE
+		}
+		log.Logger.Errorf("%s error, cancel job[%s] error: %v", m, job.Id, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "cancel job error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("job %s[%s] is cancelled by %s", job.Name, job.Id, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, job
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,223 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"sync"
+)
+
+const MONGO_COLLECTION_JOB = "jobs"
+
+var (
+	ErrJobNotFound      = errors.New("job dose not exist")
+	ErrJobStatusChanged = errors.New("job dose not exist or its status is changed")
+)
+
+// storage of jobs, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type JobRepository interface {
+	Insert(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id string) (*Job, error)
+	Find(ctx context.Context, filter *JobFilter) ([]*Job, error)
+	Update(ctx context.Context, job *Job) error
+	UpdateIf(ctx context.Context, job *Job, status string) error // job is saved only if its stored status is status
+}
+
+// empty field is not used to filter
+type JobFilter struct {
+	Status   string `form:"status"`
+	Name     string `form:"name"`
+	Caller   string `form:"caller"`
+	HostIp   string `form:"ip"`
+	Schedule string `form:"schedule"`
+	Limit    int64  `form:"limit"` // newest jobs are returned, 0 is no limit
+
+	// only jobs of the user or in the projects are found if the user is set
+	visibleUser     string
+	visibleProjects []string
+}
+
+// jobs which can be read by non-admin user
+func (f *JobFilter) visibleTo(user *User) {
+	f.visibleUser, f.visibleProjects = user.Username, user.Projects
+}
+
+func (f *JobFilter) match(j *Job) bool {
+	return (f.Status == "" || f.Status == j.Status) &&
+		(f.Name == "" || f.Name == j.Name) &&
+		(f.Caller == "" || f.Caller == j.Caller) &&
+		(f.HostIp == "" || f.HostIp == j.HostIp) &&
+		(f.Schedule == "" || f.Schedule == j.Schedule) &&
+		(f.visibleUser == "" || f.visibleUser == j.Caller || (j.Configuration != nil && j.Configuration.Project != "" && stringIn(j.Configuration.Project, f.visibleProjects)))
+}
+
+func (f *JobFilter) bson() bson.M {
+	filter := bson.M{}
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+	if f.Name != "" {
+		filter["name"] = f.Name
+	}
+	if f.Caller != "" {
+		filter["caller"] = f.Caller
+	}
+	if f.HostIp != "" {
+		filter["hostIp"] = f.HostIp
+	}
+	if f.Schedule != "" {
+		filter["schedule"] = f.Schedule
+	}
+	if f.visibleUser != "" {
+		filter["$or"] = bson.A{
+			bson.M{"caller": f.visibleUser},
+			bson.M{"configuration.project": bson.M{"$in": f.visibleProjects}},
+		}
+	}
+	return filter
+}
+
+type mongoJobRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoJobRepository(m *commons.MONGO) JobRepository {
+	return &mongoJobRepository{collection: m.Collection(MONGO_COLLECTION_JOB)}
+}
+
+func (r *mongoJobRepository) Insert(ctx context.Context, job *Job) (err error) {
+	_, err = r.collection.InsertOne(ctx, job)
+	return
+}
+
+func (r *mongoJobRepository) Get(ctx context.Context, id string) (job *Job, err error) {
+	job = new(Job)
+	if err = r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrJobNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoJobRepository) Find(ctx context.Context, filter *JobFilter) (jobs []*Job, err error) {
+	var (
+		cursor  *mongo.Cursor
+		findOpt = options.Find().SetSort(bson.D{{Key: "createTime", Value: -1}})
+	)
+	if filter.Limit > 0 {
+		findOpt.SetLimit(filter.Limit)
+	}
+
+	if cursor, err = r.collection.Find(ctx, filter.bson(), findOpt); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	jobs = make([]*Job, 0)
+	err = cursor.All(ctx, &jobs)
+	return
+}
+
+func (r *mongoJobRepository) Update(ctx context.Context, job *Job) (err error) {
+	var (
+		result *mongo.UpdateResult
+	)
+	if result, err = r.collection.ReplaceOne(ctx, bson.M{"_id": job.Id}, job); err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobNotFound
+	}
+	return
+}
+
+func (r *mongoJobRepository) UpdateIf(ctx context.Context, job *Job, status string) (err error) {
+	var (
+		result *mongo.UpdateResult
+	)
+	if result, err = r.collection.ReplaceOne(ctx, bson.M{"_id": job.Id, "status": status}, job); err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobStatusChanged
+	}
+	return
+}
+
+type memoryJobRepository struct {
+	mu   sync.RWMutex
+	jobs []*Job
+}
+
+func NewMemoryJobRepository() JobRepository {
+	return &memoryJobRepository{jobs: make([]*Job, 0)}
+}
+
+func (r *memoryJobRepository) Insert(ctx context.Context, job *Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j := *job
+	r.jobs = append(r.jobs, &j)
+	return nil
+}
+
+func (r *memoryJobRepository) Get(ctx context.Context, id string) (*Job, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, j := range r.jobs {
+		if j.Id == id {
+			job := *j
+			return &job, nil
+		}
+	}
+	return nil, ErrJobNotFound
+}
+
+func (r *memoryJobRepository) Find(ctx context.Context, filter *JobFilter) ([]*Job, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// newest first
+	jobs := make([]*Job, 0)
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && int64(len(jobs)) >= filter.Limit {
+			break
+		}
+		if filter.match(r.jobs[i]) {
+			job := *r.jobs[i]
+			jobs = append(jobs, &job)
+		}
+	}
+	return jobs, nil
+}
+
+func (r *memoryJobRepository) Update(ctx context.Context, job *Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, j := range r.jobs {
+		if j.Id == job.Id {
+			updated := *job
+			r.jobs[i] = &updated
+			return nil
+		}
+	}
+	return ErrJobNotFound
+}
+
+func (r *memoryJobRepository) UpdateIf(ctx context.Context, job *Job, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, j := range r.jobs {
+		if j.Id == job.Id && j.Status == status {
+			updated := *job
+			r.jobs[i] = &updated
+			return nil
+		}
+	}
+	return ErrJobStatusChanged
+}
@@ -0,0 +1,47 @@
+package apps
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryJobRepositoryUpdateIf(t *testing.T) {
+	var (
+		ctx  = context.TODO()
+		repo = NewMemoryJobRepository()
+	)
+
+	if err := repo.Insert(ctx, &Job{Id: "1", Status: JOB_STATUS_PENDING}); err != nil {
+		t.Fatalf("Insert() error: %v", err)
+	}
+
+	// start and cancel of the same pending job, only the first one succeeds
+	tests := []struct {
+		name   string
+		job    *Job
+		from   string
+		want   error
+		status string
+	}{
+		{"start pending job", &Job{Id: "1", Status: JOB_STATUS_RUNNING}, JOB_STATUS_PENDING, nil, JOB_STATUS_RUNNING},
+		{"cancel started job", &Job{Id: "1", Status: JOB_STATUS_CANCELLED}, JOB_STATUS_PENDING, ErrJobStatusChanged, JOB_STATUS_RUNNING},
+		{"back to queue", &Job{Id: "1", Status: JOB_STATUS_PENDING}, JOB_STATUS_RUNNING, nil, JOB_STATUS_PENDING},
+		{"cancel pending job", &Job{Id: "1", Status: JOB_STATUS_CANCELLED}, JOB_STATUS_PENDING, nil, JOB_STATUS_CANCELLED},
+		{"start cancelled job", &Job{Id: "1", Status: JOB_STATUS_RUNNING}, JOB_STATUS_PENDING, ErrJobStatusChanged, JOB_STATUS_CANCELLED},
+		{"missing job", &Job{Id: "2", Status: JOB_STATUS_RUNNING}, JOB_STATUS_PENDING, ErrJobStatusChanged, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := repo.UpdateIf(ctx, tt.job, tt.from); err != tt.want {
+				t.Fatalf("UpdateIf() error = %v, want %v", err, tt.want)
+			}
+			if tt.status == "" {
+				return
+			}
+			if job, _ := repo.Get(ctx, tt.job.Id); job.Status != tt.status {
+				t.Errorf("status after UpdateIf() = %s, want %s", job.Status, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"iCloud/commons"
+	"sync"
+)
+
+const MONGO_COLLECTION_MOVED_CONTAINER = "movedContainers"
+
+var ErrMovedContainerNotFound = errors.New("moved container dose not exist")
+
+// storage of containers moved from offline hosts by supervisor, so they are removed when their host is online even after restart.
+// it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type MovedContainerRepository interface {
+	Insert(ctx context.Context, c *SupervisedContainer) error
+	Find(ctx context.Context, hostIp string) ([]*SupervisedContainer, error) // all containers if hostIp is empty
+	Delete(ctx context.Context, id string) error
+}
+
+type mongoMovedContainerRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoMovedContainerRepository(m *commons.MONGO) MovedContainerRepository {
+	return &mongoMovedContainerRepository{collection: m.Collection(MONGO_COLLECTION_MOVED_CONTAINER)}
+}
+
+func (r *mongoMovedContainerRepository) Insert(ctx context.Context, c *SupervisedContainer) (err error) {
+	_, err = r.collection.InsertOne(ctx, c)
+	return
+}
+
+func (r *mongoMovedContainerRepository) Find(ctx context.Context, hostIp string) (containers []*SupervisedContainer, err error) {
+	var (
+		cursor *mongo.Cursor
+		filter = bson.M{}
+	)
+	if hostIp != "" {
+		filter["hostIp"] = hostIp
+	}
+
+	if cursor, err = r.collection.Find(ctx, filter); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	containers = make([]*SupervisedContainer, 0)
+	err = cursor.All(ctx, &containers)
+	return
+}
+
+func (r *mongoMovedContainerRepository) Delete(ctx context.Context, id string) (err error) {
+	var (
+		result *mongo.DeleteResult
+	)
+	if result, err = r.collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return
+	}
+	if result.DeletedCount == 0 {
+		return ErrMovedContainerNotFound
+	}
+	return
+}
+
+type memoryMovedContainerRepository struct {
+	mu         sync.RWMutex
+	containers []*SupervisedContainer
+}
+
+func NewMemoryMovedContainerRepository() MovedContainerRepository {
+	return &memoryMovedContainerRepository{containers: make([]*SupervisedContainer, 0)}
+}
+
+func (r *memoryMovedContainerRepository) Insert(ctx context.Context, c *SupervisedContainer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	moved := *c
+	r.containers = append(r.containers, &moved)
+	return nil
+}
+
+func (r *memoryMovedContainerRepository) Find(ctx context.Context, hostIp string) ([]*SupervisedContainer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	containers := make([]*SupervisedContainer, 0)
+	for _, c := range r.containers {
+		if hostIp == "" || c.HostIp == hostIp {
+			moved := *c
+			containers = append(containers, &moved)
+		}
+	}
+	return containers, nil
+}
+
+func (r *memoryMovedContainerRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.containers {
+		if c.Id == id {
+			r.containers = append(r.containers[:i], r.containers[i+1:]...)
+			return nil
+		}
+	}
+	return ErrMovedContainerNotFound
+}
@@ -0,0 +1,224 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/commons"
+	"iCloud/log"
+	"net/http"
+	"strings"
+)
+
+// networks created by docker, they can not be created or removed
+var predefinedNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+type NetworkCreateRequest struct {
+	Name     string            `json:"name"`
+	Driver   string            `json:"driver"` // bridge by default
+	Internal bool              `json:"internal"`
+	Subnet   string            `json:"subnet"` // e.g. "172.30.0.0/16", it is assigned by docker if it is not set
+	Gateway  string            `json:"gateway"`
+	Project  string            `json:"project"`
+	Labels   map[string]string `json:"labels"` // labels with prefix "iCloud." are kept by iCloud
+}
+
+func (req *NetworkCreateRequest) check(user *User) error {
+	if req.Name == "" || predefinedNetworks[req.Name] {
+		return errors.New("name of network is null or name of network created by docker")
+	}
+	if req.Gateway != "" && req.Subnet == "" {
+		return errors.New("subnet is required if gateway is set")
+	}
+	if req.Project != "" && !user.IsAdmin() && !user.InProject(req.Project) {
+		return errors.New("permission denied, caller is not member of project " + req.Project)
+	}
+	for k := range req.Labels {
+		if k == "" || strings.HasPrefix(k, LABEL_PREFIX) {
+			return errors.New("label can not be null or start with " + LABEL_PREFIX)
+		}
+	}
+	return nil
+}
+
+// network which is not created by iCloud has no owner, so it can be removed only by admin
+func networkAllowed(user *User, detail *types.NetworkResource, permission string) bool {
+	return user.Allowed(detail.Labels[LABEL_OWNER], detail.Labels[LABEL_PROJECT], permission)
+}
+
+// user-defined network must be writable by owner of container, it is checked on host where container is created
+func containerNetworkCheck(cli *client.Client, conf *ContainerConfiguration) error {
+	var (
+		m      = "apps.network.containerNetworkCheck()"
+		user   *User
+		detail types.NetworkResource
+		err    error
+	)
+
+	if conf.Network == "" || !container.NetworkMode(conf.Network).IsUserDefined() {
+		return nil
+	}
+
+	checkCtx, cancel := context.WithTimeout(context.TODO(), commons.MONGO_TIMEOUT)
+	defer cancel()
+	if user, err = Users.Get(checkCtx, conf.Owner); err != nil {
+		log.Logger.Errorf("%s error, get owner %s of container error: %v", m, conf.Owner, err)
+		return errors.New("get owner of container error")
+	}
+
+	if detail, err = cli.NetworkInspect(context.TODO(), conf.Network, types.NetworkInspectOptions{}); err != nil {
+		log.Logger.Errorf("%s error, inspect network %s error: %v", m, conf.Network, err)
+		return errors.New("network " + conf.Network + " is not found on host")
+	}
+	if !networkAllowed(user, &detail, PERMISSION_WRITE) {
+		return errors.New("permission denied, network " + conf.Network + " is not writable by " + user.Username)
+	}
+	return nil
+}
+
+// container is attached to its network with aliases when it is created
+func networkConfInit(conf *ContainerConfiguration) *network.NetworkingConfig {
+	if conf.Network == "" {
+		return nil
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			conf.Network: {Aliases: conf.NetworkAliases},
+		},
+	}
+}
+
+func NetworkList(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.network.NetworkList()"
+		ip       = ctx.Param("ip")
+		cli      *client.Client
+		networks []types.NetworkResource
+		err      error
+	)
+
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if networks, err = cli.NetworkList(context.TODO(), types.NetworkListOptions{}); err != nil {
+		log.Logger.Errorf("%s error, list networks on %s error: %v", m, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list networks error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, networks
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// create network on host, it is owned by caller
+func NetworkCreate(ctx *gin.Context) {
+	var (
+		rsp     = make(gin.H)
+		m       = "apps.network.NetworkCreate()"
+		ip      = ctx.Param("ip")
+		user    = callerUser(ctx)
+		req     = new(NetworkCreateRequest)
+		options types.NetworkCreate
+		created types.NetworkCreateResponse
+		cli     *client.Client
+		err     error
+	)
+
+	if err = ctx.BindJSON(req); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+	if err = req.check(user); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	options = types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         req.Driver,
+		Internal:       req.Internal,
+		Labels:         map[string]string{LABEL_OWNER: user.Username, LABEL_PROJECT: req.Project},
+	}
+	for k, v := range req.Labels {
+		options.Labels[k] = v
+	}
+	if req.Subnet != "" {
+		options.IPAM = &network.IPAM{Config: []network.IPAMConfig{{Subnet: req.Subnet, Gateway: req.Gateway}}}
+	}
+
+	if created, err = cli.NetworkCreate(context.TODO(), req.Name, options); err != nil {
+		log.Logger.Errorf("%s error, create network %s on %s error: %v", m, req.Name, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "create network error: "+err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("network %s[%s] on %s is created by %s", req.Name, created.ID, ip, user.Username)
+	rsp["ErrorCode"], rsp["Data"] = 0, created.ID
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// remove network of "network" in url, it is name or id, network with containers attached can not be removed
+func NetworkRemove(ctx *gin.Context) {
+	var (
+		rsp    = make(gin.H)
+		m      = "apps.network.NetworkRemove()"
+		ip, id = ctx.Param("ip"), ctx.Query("network")
+		cli    *client.Client
+		detail types.NetworkResource
+		err    error
+	)
+
+	if id == "" || predefinedNetworks[id] {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, network is required in url and it can not be created by docker"
+		goto RESPONSE
+	}
+
+	if cli, err = DockerClientPool.Get(ip, ctx.Param("port")); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if detail, err = cli.NetworkInspect(context.TODO(), id, types.NetworkInspectOptions{}); err != nil {
+		log.Logger.Errorf("%s error, inspect network %s on %s error: %v", m, id, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "inspect network error"
+		goto RESPONSE
+	}
+	if predefinedNetworks[detail.Name] {
+		rsp["ErrorCode"], rsp["Data"] = 1, "network created by docker can not be removed"
+		goto RESPONSE
+	}
+	if !networkAllowed(callerUser(ctx), &detail, PERMISSION_WRITE) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+	if len(detail.Containers) > 0 {
+		rsp["ErrorCode"], rsp["Data"] = 1, "network is used by containers, remove them or detach them first"
+		goto RESPONSE
+	}
+
+	if err = cli.NetworkRemove(context.TODO(), detail.ID); err != nil {
+		log.Logger.Errorf("%s error, remove network %s on %s error: %v", m, id, ip, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "remove network error: "+err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("network %s[%s] on %s is removed by %s", detail.Name, detail.ID, ip, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, struct{}{}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,527 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/gin-gonic/gin"
+	"iCloud/commons"
+	"iCloud/conf"
+	"iCloud/log"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const (
+	QUOTA_SCOPE_USER    = "user"
+	QUOTA_SCOPE_PROJECT = "project"
+
+	UPLOAD_OWNER_FILE = ".iCloud.owner" // owner and project of uploaded file, it is in dir of upload
+)
+
+var (
+	Quotas        = NewMemoryQuotaRepository()
+	quotaReserved = newQuotaReservation()
+)
+
+// limits of user or project, 0 is unlimited
+type Quota struct {
+	Id            string  `json:"id" bson:"_id"`
+	Scope         string  `json:"scope" bson:"scope"`
+	Name          string  `json:"name" bson:"name"`
+	MaxCpu        float64 `json:"maxCpu" bson:"maxCpu"`               // cores
+	MaxMem        float64 `json:"maxMem" bson:"maxMem"`               // GB
+	MaxContainers int     `json:"maxContainers" bson:"maxContainers"` // containers which are not exited
+	MaxUploadDisk float64 `json:"maxUploadDisk" bson:"maxUploadDisk"` // GB of uploaded files
+	IsDefault     bool    `json:"isDefault" bson:"-"`                 // quota is from configuration
+}
+
+type QuotaRequest struct {
+	MaxCpu        float64 `json:"maxCpu"`
+	MaxMem        float64 `json:"maxMem"`
+	MaxContainers int     `json:"maxContainers"`
+	MaxUploadDisk float64 `json:"maxUploadDisk"`
+}
+
+type QuotaUsage struct {
+	Cpu        float64 `json:"cpu"`
+	Mem        float64 `json:"mem"`
+	Containers int     `json:"containers"`
+	UploadDisk float64 `json:"uploadDisk"`
+}
+
+// consumed vs allowed of user or project
+type QuotaReport struct {
+	Scope string      `json:"scope"`
+	Name  string      `json:"name"`
+	Limit *Quota      `json:"limit"`
+	Used  *QuotaUsage `json:"used"`
+}
+
+type uploadOwner struct {
+	Owner   string `json:"owner"`
+	Project string `json:"project"`
+}
+
+// resources of containers which pass quota check but are not created yet, they are counted in usage until they are created,
+// and check of one user or project is serialized, so concurrent creates can not exceed its quota
+type quotaReservation struct {
+	mu       sync.Mutex
+	locks    map[string]*sync.Mutex // key is scope/name
+	reserved map[string]*QuotaUsage
+}
+
+func newQuotaReservation() *quotaReservation {
+	return &quotaReservation{locks: make(map[string]*sync.Mutex), reserved: make(map[string]*QuotaUsage)}
+}
+
+func (r *quotaReservation) lock(key string) (unlock func()) {
+	r.mu.Lock()
+	l, exist := r.locks[key]
+	if !exist {
+		l = new(sync.Mutex)
+		r.locks[key] = l
+	}
+	r.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// count is negative when reservation is released
+func (r *quotaReservation) add(key string, count int, cpu, mem float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage, exist := r.reserved[key]
+	if !exist {
+		usage = new(QuotaUsage)
+		r.reserved[key] = usage
+	}
+	usage.Containers, usage.Cpu, usage.Mem = usage.Containers+count, usage.Cpu+cpu, usage.Mem+mem
+	if usage.Containers <= 0 {
+		delete(r.reserved, key)
+	}
+}
+
+func (r *quotaReservation) get(key string) QuotaUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if usage, exist := r.reserved[key]; exist {
+		return *usage
+	}
+	return QuotaUsage{}
+}
+
+func quotaScopeValid(scope string) bool {
+	return scope == QUOTA_SCOPE_USER || scope == QUOTA_SCOPE_PROJECT
+}
+
+// quota set by admin, default quota in configuration is used if it is not set
+func quotaOf(ctx context.Context, scope, name string) (quota *Quota, err error) {
+	var (
+		limit conf.QuotaLimit
+	)
+
+	if quota, err = Quotas.Get(ctx, scope, name); err != ErrQuotaNotFound {
+		return
+	}
+
+	if limit = conf.Iconf.Quota.User; scope == QUOTA_SCOPE_PROJECT {
+		limit = conf.Iconf.Quota.Project
+	}
+	return &Quota{
+		Id:            quotaId(scope, name),
+		Scope:         scope,
+		Name:          name,
+		MaxCpu:        limit.MaxCpu,
+		MaxMem:        limit.MaxMem,
+		MaxContainers: limit.MaxContainers,
+		MaxUploadDisk: limit.MaxUploadDisk,
+		IsDefault:     true,
+	}, nil
+}
+
+func quotaLabel(scope string) string {
+	if scope == QUOTA_SCOPE_PROJECT {
+		return LABEL_PROJECT
+	}
+	return LABEL_OWNER
+}
+
+// resources of containers which are not exited on online hosts, containers are found by owner or project label.
+// usage can not be computed if any online host can not be connected
+func quotaUsage(ctx context.Context, scope, name string) (usage *QuotaUsage, err error) {
+	var (
+		m          = "apps.quota.quotaUsage()"
+		hosts      []*commons.Host
+		containers []types.Container
+		detail     types.ContainerJSON
+		args       = filters.NewArgs(filters.Arg("label", quotaLabel(scope)+"="+name))
+	)
+
+	for _, status := range []string{"created", "running", "paused", "restarting"} {
+		args.Add("status", status)
+	}
+
+	usage = new(QuotaUsage)
+	hosts, _ = HostRegistry.List()
+	for _, host := range hosts {
+		cli, err1 := DockerClientPool.Get(host.Ip, host.ApiPort)
+		if err1 != nil {
+			log.Logger.Errorf("%s error, connect to docker api of %s error: %v", m, host.Ip, err1)
+			return nil, errors.New("get usage error, host " + host.Ip + " can not be connected")
+		}
+		if containers, err1 = cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args}); err1 != nil {
+			log.Logger.Errorf("%s error, list containers of %s error: %v", m, host.Ip, err1)
+			return nil, errors.New("get usage error, list containers of " + host.Ip + " error")
+		}
+
+		for _, c := range containers {
+			usage.Containers++
+			if detail, err1 = cli.ContainerInspect(ctx, c.ID); err1 != nil || detail.HostConfig == nil {
+				log.Logger.Errorf("%s error, get container[%s] inspect of %s error: %v", m, c.ID, host.Ip, err1)
+				return nil, errors.New("get usage error, inspect container of " + host.Ip + " error")
+			}
+			resources := detail.HostConfig.Resources
+			if resources.NanoCPUs > 0 {
+				usage.Cpu += float64(resources.NanoCPUs) / 1e9
+			} else if resources.CPUQuota > 0 && resources.CPUPeriod > 0 {
+				usage.Cpu += float64(resources.CPUQuota) / float64(resources.CPUPeriod)
+			}
+			usage.Mem += float64(resources.Memory) / float64(commons.GB)
+		}
+	}
+
+	if usage.UploadDisk, err = uploadDiskUsage(scope, name); err != nil {
+		log.Logger.Errorf("%s error, get disk of uploaded files of %s %s error: %v", m, scope, name, err)
+		return nil, errors.New("get disk of uploaded files error")
+	}
+	return
+}
+
+// GB of files uploaded by user or to project
+func uploadDiskUsage(scope, name string) (disk float64, err error) {
+	var (
+		dirs  []os.FileInfo
+		owner *uploadOwner
+		size  int64
+	)
+
+	if dirs, err = ioutil.ReadDir(UploadTempDir); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		if owner, err = uploadOwnerRead(path.Join(UploadTempDir, dir.Name())); err != nil {
+			// upload before quota is enabled has no owner
+			err = nil
+			continue
+		}
+		if (scope == QUOTA_SCOPE_USER && owner.Owner != name) || (scope == QUOTA_SCOPE_PROJECT && owner.Project != name) {
+			continue
+		}
+
+		err = filepath.Walk(path.Join(UploadTempDir, dir.Name()), func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	return float64(size) / float64(commons.GB), nil
+}
+
+func uploadOwnerWrite(dir string, owner *uploadOwner) error {
+	content, _ := json.Marshal(owner)
+	return ioutil.WriteFile(path.Join(dir, UPLOAD_OWNER_FILE), content, 0644)
+}
+
+func uploadOwnerRead(dir string) (owner *uploadOwner, err error) {
+	var (
+		content []byte
+	)
+	if content, err = ioutil.ReadFile(path.Join(dir, UPLOAD_OWNER_FILE)); err != nil {
+		return
+	}
+	owner = new(uploadOwner)
+	err = json.Unmarshal(content, owner)
+	return
+}
+
+func quotaExceeded(quota *Quota, item string, used, add, max float64) error {
+	return fmt.Errorf("quota of %s %s is exceeded, %s %s used + %s requested > %s allowed", quota.Scope, quota.Name, item,
+		strconv.FormatFloat(used, 'f', -1, 64), strconv.FormatFloat(add, 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
+}
+
+// check if quota can hold one more container with cpu and mem
+func quotaContainerFit(quota *Quota, usage *QuotaUsage, cpu, mem float64) error {
+	switch {
+	case quota.MaxContainers > 0 && usage.Containers+1 > quota.MaxContainers:
+		return quotaExceeded(quota, "containers", float64(usage.Containers), 1, float64(quota.MaxContainers))
+	case quota.MaxCpu > 0 && usage.Cpu+cpu > quota.MaxCpu:
+		return quotaExceeded(quota, "cpu", usage.Cpu, cpu, quota.MaxCpu)
+	case quota.MaxMem > 0 && usage.Mem+mem > quota.MaxMem:
+		return quotaExceeded(quota, "mem(GB)", usage.Mem, mem, quota.MaxMem)
+	}
+	return nil
+}
+
+// scopes which quota of them is applied to caller, admin is not limited by user quota
+func quotaScopes(user *User, project string) [][2]string {
+	scopes := make([][2]string, 0, 2)
+	if !user.IsAdmin() {
+		scopes = append(scopes, [2]string{QUOTA_SCOPE_USER, user.Username})
+	}
+	if project != "" {
+		scopes = append(scopes, [2]string{QUOTA_SCOPE_PROJECT, project})
+	}
+	return scopes
+}
+
+// check quotas of owner and project of container against containers which are running and reserved,
+// it must be called after containerOwnerSet, and release must be called after container is created or fails to be created
+func containerQuotaCheck(ctx *gin.Context, conf *ContainerConfiguration) (release func(), err error) {
+	return userQuotaCheck(ctx, callerUser(ctx), conf)
+}
+
+// check quotas of user and project of container, it is used when container is created without request, e.g. by scheduler.
+// resources of container are reserved until release is called
+func userQuotaCheck(ctx context.Context, user *User, conf *ContainerConfiguration) (release func(), err error) {
+	var (
+		m        = "apps.quota.userQuotaCheck()"
+		cpu, mem float64
+		quota    *Quota
+		usage    *QuotaUsage
+		reserved = make([]string, 0, 2)
+	)
+
+	release = func() {
+		for _, key := range reserved {
+			quotaReserved.add(key, -1, -cpu, -mem)
+		}
+		reserved = nil
+	}
+
+	if cpu, err = strconv.ParseFloat(conf.MaxCpu, 64); err != nil {
+		return release, errors.New("type of MaxCpu is not number")
+	}
+	if mem, err = strconv.ParseFloat(conf.MaxMem, 64); err != nil {
+		return release, errors.New("type of MaxMem is not number")
+	}
+
+	for _, scope := range quotaScopes(user, conf.Project) {
+		if quota, err = quotaOf(ctx, scope[0], scope[1]); err != nil {
+			log.Logger.Errorf("%s error, get quota of %s %s error: %v", m, scope[0], scope[1], err)
+			err = errors.New("get quota error")
+			break
+		}
+		if quota.MaxCpu <= 0 && quota.MaxMem <= 0 && quota.MaxContainers <= 0 {
+			continue
+		}
+
+		key := scope[0] + "/" + scope[1]
+		unlock := quotaReserved.lock(key)
+		if usage, err = quotaUsage(ctx, scope[0], scope[1]); err == nil {
+			r := quotaReserved.get(key)
+			usage.Containers, usage.Cpu, usage.Mem = usage.Containers+r.Containers, usage.Cpu+r.Cpu, usage.Mem+r.Mem
+			if err = quotaContainerFit(quota, usage, cpu, mem); err != nil {
+				log.Logger.Infof("container %s is rejected, %v", conf.ContainerName, err)
+			} else {
+				quotaReserved.add(key, 1, cpu, mem)
+				reserved = append(reserved, key)
+			}
+		}
+		unlock()
+		if err != nil {
+			break
+		}
+	}
+
+	// reservation of scopes checked before is released if container is rejected
+	if err != nil {
+		release()
+	}
+	return
+}
+
+// check quotas of caller and project for uploaded file of size bytes
+func uploadQuotaCheck(ctx *gin.Context, project string, size int64) (err error) {
+	var (
+		m     = "apps.quota.uploadQuotaCheck()"
+		quota *Quota
+		disk  float64
+		add   = float64(size) / float64(commons.GB)
+	)
+
+	for _, scope := range quotaScopes(callerUser(ctx), project) {
+		if quota, err = quotaOf(ctx, scope[0], scope[1]); err != nil {
+			log.Logger.Errorf("%s error, get quota of %s %s error: %v", m, scope[0], scope[1], err)
+			return errors.New("get quota error")
+		}
+		if quota.MaxUploadDisk <= 0 {
+			continue
+		}
+		if disk, err = uploadDiskUsage(scope[0], scope[1]); err != nil {
+			log.Logger.Errorf("%s error, get disk of uploaded files of %s %s error: %v", m, scope[0], scope[1], err)
+			return errors.New("get disk of uploaded files error")
+		}
+		if disk+add > quota.MaxUploadDisk {
+			return quotaExceeded(quota, "upload disk(GB)", disk, add, quota.MaxUploadDisk)
+		}
+	}
+	return nil
+}
+
+// usage and quota of caller and projects of caller, admin can get them of any user or project by "?user=" or "?project="
+func QuotaUsageGet(ctx *gin.Context) {
+	var (
+		rsp     = make(gin.H)
+		m       = "apps.quota.QuotaUsageGet()"
+		user    = callerUser(ctx)
+		scopes  = make([][2]string, 0)
+		reports = make([]*QuotaReport, 0)
+		quota   *Quota
+		usage   *QuotaUsage
+		err     error
+	)
+
+	if name := ctx.Query("user"); name != "" {
+		scopes = append(scopes, [2]string{QUOTA_SCOPE_USER, name})
+	}
+	if name := ctx.Query("project"); name != "" {
+		scopes = append(scopes, [2]string{QUOTA_SCOPE_PROJECT, name})
+	}
+	if len(scopes) == 0 {
+		scopes = append(scopes, [2]string{QUOTA_SCOPE_USER, user.Username})
+		for _, project := range user.Projects {
+			scopes = append(scopes, [2]string{QUOTA_SCOPE_PROJECT, project})
+		}
+	}
+
+	for _, scope := range scopes {
+		if !user.IsAdmin() && !(scope[0] == QUOTA_SCOPE_USER && scope[1] == user.Username) &&
+			!(scope[0] == QUOTA_SCOPE_PROJECT && user.InProject(scope[1])) {
+			rsp["ErrorCode"], rsp["Data"] = 1, "permission denied, quota of "+scope[0]+" "+scope[1]+" is not allowed"
+			goto RESPONSE
+		}
+		if quota, err = quotaOf(ctx, scope[0], scope[1]); err != nil {
+			log.Logger.Errorf("%s error, get quota of %s %s error: %v", m, scope[0], scope[1], err)
+			rsp["ErrorCode"], rsp["Data"] = 1, "get quota error"
+			goto RESPONSE
+		}
+		if usage, err = quotaUsage(ctx, scope[0], scope[1]); err != nil {
+			rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+			goto RESPONSE
+		}
+		reports = append(reports, &QuotaReport{Scope: scope[0], Name: scope[1], Limit: quota, Used: usage})
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, reports
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// quotas set by admin and default quotas in configuration
+func QuotaList(ctx *gin.Context) {
+	var (
+		rsp    = make(gin.H)
+		m      = "apps.quota.QuotaList()"
+		quotas []*Quota
+		err    error
+	)
+
+	if quotas, err = Quotas.List(ctx); err != nil {
+		log.Logger.Errorf("%s error, list quotas error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list quotas error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, gin.H{"quotas": quotas, "default": conf.Iconf.Quota}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// set quota of user or project, scope is "user" or "project"
+func QuotaSet(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		m     = "apps.quota.QuotaSet()"
+		scope = ctx.Param("scope")
+		name  = ctx.Param("name")
+		req   = new(QuotaRequest)
+		quota *Quota
+		err   error
+	)
+
+	if !quotaScopeValid(scope) {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, scope is user or project"
+		goto RESPONSE
+	}
+	if err = ctx.BindJSON(req); err != nil || req.MaxCpu < 0 || req.MaxMem < 0 || req.MaxContainers < 0 || req.MaxUploadDisk < 0 {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, limit can not be less than 0"
+		goto RESPONSE
+	}
+
+	quota = &Quota{
+		Scope:         scope,
+		Name:          name,
+		MaxCpu:        req.MaxCpu,
+		MaxMem:        req.MaxMem,
+		MaxContainers: req.MaxContainers,
+		MaxUploadDisk: req.MaxUploadDisk,
+	}
+	if err = Quotas.Upsert(ctx, quota); err != nil {
+		log.Logger.Errorf("%s error, set quota of %s %s error: %v", m, scope, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "set quota error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("quota of %s %s is set to %+v by %s", scope, name, *req, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, quota
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// delete quota of user or project, default quota is used after it
+func QuotaDelete(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		m     = "apps.quota.QuotaDelete()"
+		scope = ctx.Param("scope")
+		name  = ctx.Param("name")
+		err   error
+	)
+
+	if err = Quotas.Delete(ctx, scope, name); err != nil {
+		if err != ErrQuotaNotFound {
+			log.Logger.Errorf("%s error, delete quota of %s %s error: %v", m, scope, name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "delete quota error: "+err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("quota of %s %s is deleted by %s", scope, name, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, struct{}{}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,132 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"sort"
+	"sync"
+)
+
+const MONGO_COLLECTION_QUOTA = "quotas"
+
+var ErrQuotaNotFound = errors.New("quota dose not exist")
+
+// storage of quotas set by admin, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type QuotaRepository interface {
+	Get(ctx context.Context, scope, name string) (*Quota, error)
+	List(ctx context.Context) ([]*Quota, error)
+	Upsert(ctx context.Context, quota *Quota) error
+	Delete(ctx context.Context, scope, name string) error
+}
+
+func quotaId(scope, name string) string {
+	return scope + ":" + name
+}
+
+type mongoQuotaRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoQuotaRepository(m *commons.MONGO) QuotaRepository {
+	return &mongoQuotaRepository{collection: m.Collection(MONGO_COLLECTION_QUOTA)}
+}
+
+func (r *mongoQuotaRepository) Get(ctx context.Context, scope, name string) (quota *Quota, err error) {
+	quota = new(Quota)
+	if err = r.collection.FindOne(ctx, bson.M{"_id": quotaId(scope, name)}).Decode(quota); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrQuotaNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoQuotaRepository) List(ctx context.Context) (quotas []*Quota, err error) {
+	var (
+		cursor *mongo.Cursor
+	)
+
+	if cursor, err = r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	quotas = make([]*Quota, 0)
+	err = cursor.All(ctx, &quotas)
+	return
+}
+
+func (r *mongoQuotaRepository) Upsert(ctx context.Context, quota *Quota) (err error) {
+	quota.Id = quotaId(quota.Scope, quota.Name)
+	_, err = r.collection.ReplaceOne(ctx, bson.M{"_id": quota.Id}, quota, options.Replace().SetUpsert(true))
+	return
+}
+
+func (r *mongoQuotaRepository) Delete(ctx context.Context, scope, name string) (err error) {
+	var (
+		result *mongo.DeleteResult
+	)
+	if result, err = r.collection.DeleteOne(ctx, bson.M{"_id": quotaId(scope, name)}); err != nil {
+		return
+	}
+	if result.DeletedCount == 0 {
+		return ErrQuotaNotFound
+	}
+	return
+}
+
+type memoryQuotaRepository struct {
+	mu     sync.RWMutex
+	quotas map[string]*Quota
+}
+
+func NewMemoryQuotaRepository() QuotaRepository {
+	return &memoryQuotaRepository{quotas: make(map[string]*Quota)}
+}
+
+func (r *memoryQuotaRepository) Get(ctx context.Context, scope, name string) (*Quota, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if q, exist := r.quotas[quotaId(scope, name)]; exist {
+		quota := *q
+		return &quota, nil
+	}
+	return nil, ErrQuotaNotFound
+}
+
+func (r *memoryQuotaRepository) List(ctx context.Context) ([]*Quota, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	quotas := make([]*Quota, 0, len(r.quotas))
+	for _, q := range r.quotas {
+		quota := *q
+		quotas = append(quotas, &quota)
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Id < quotas[j].Id })
+	return quotas, nil
+}
+
+func (r *memoryQuotaRepository) Upsert(ctx context.Context, quota *Quota) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	quota.Id = quotaId(quota.Scope, quota.Name)
+	q := *quota
+	r.quotas[quota.Id] = &q
+	return nil
+}
+
+func (r *memoryQuotaRepository) Delete(ctx context.Context, scope, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := quotaId(scope, name)
+	if _, exist := r.quotas[id]; !exist {
+		return ErrQuotaNotFound
+	}
+	delete(r.quotas, id)
+	return nil
+}
@@ -0,0 +1,205 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/log"
+	"net/http"
+)
+
+const (
+	ROLE_ADMIN  = "admin"  // all resources
+	ROLE_MEMBER = "member" // resources owned by self or in own projects
+	ROLE_VIEWER = "viewer" // read resources owned by self or in own projects
+
+	PERMISSION_READ  = "read"
+	PERMISSION_WRITE = "write"
+
+	LABEL_OWNER   = "iCloud.owner"
+	LABEL_PROJECT = "iCloud.project"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+type UserRoleRequest struct {
+	Role     string   `json:"role"`
+	Projects []string `json:"projects"`
+}
+
+func roleValid(role string) bool {
+	return role == ROLE_ADMIN || role == ROLE_MEMBER || role == ROLE_VIEWER
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == ROLE_ADMIN
+}
+
+func (u *User) InProject(project string) bool {
+	for _, p := range u.Projects {
+		if p == project {
+			return true
+		}
+	}
+	return false
+}
+
+// user without role is viewer
+func (u *User) HasRole(roles ...string) bool {
+	role := u.Role
+	if role == "" {
+		role = ROLE_VIEWER
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// resource of owner in project can be read or written by user
+func (u *User) Allowed(owner, project, permission string) bool {
+	switch {
+	case u.IsAdmin():
+		return true
+	case permission == PERMISSION_WRITE && !u.HasRole(ROLE_MEMBER):
+		return false
+	case owner != "" && owner == u.Username:
+		return true
+	case project != "" && u.InProject(project):
+		return true
+	}
+	return false
+}
+
+// user of caller, it is never nil after AuthRequired
+func callerUser(ctx *gin.Context) *User {
+	if caller, ok := CallerOf(ctx); ok && caller.User != nil {
+		return caller.User
+	}
+	return &User{Role: ROLE_VIEWER}
+}
+
+// middleware which rejects caller without any of roles
+func RoleRequired(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !callerUser(ctx).HasRole(roles...) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"ErrorCode": 1, "Data": "permission denied, role is not allowed"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func containerAllowed(user *User, labels map[string]string, permission string) bool {
+	return user.Allowed(labels[LABEL_OWNER], labels[LABEL_PROJECT], permission)
+}
+
+// check permission of caller to container by its owner and project labels,
+// ErrPermissionDenied is returned if it is not allowed
+func containerAuthorize(ctx *gin.Context, cli *client.Client, id, permission string) (err error) {
+	var (
+		m      = "apps.rbac.containerAuthorize()"
+		user   = callerUser(ctx)
+		detail types.ContainerJSON
+	)
+
+	if user.IsAdmin() {
+		return nil
+	}
+
+	if detail, err = cli.ContainerInspect(context.TODO(), id); err != nil {
+		log.Logger.Errorf("%s error, get container[%s] inspect error: %v", m, id, err)
+		return errors.New("get container inspect error")
+	}
+
+	if detail.Config == nil || !containerAllowed(user, detail.Config.Labels, permission) {
+		log.Logger.Infof("%s of container[%s] is denied to %s", permission, id, user.Username)
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// containers which can be read by caller
+func containersVisible(ctx *gin.Context, containers []types.Container) []types.Container {
+	var (
+		user    = callerUser(ctx)
+		visible = make([]types.Container, 0, len(containers))
+	)
+
+	if user.IsAdmin() {
+		return containers
+	}
+	for _, c := range containers {
+		if containerAllowed(user, c.Labels, PERMISSION_READ) {
+			visible = append(visible, c)
+		}
+	}
+	return visible
+}
+
+// container is owned by caller, and its project must be one of projects of caller,
+// secrets used by container must be readable by caller
+func containerOwnerSet(ctx *gin.Context, conf *ContainerConfiguration) error {
+	return userOwnerSet(ctx, callerUser(ctx), conf)
+}
+
+// container is owned by user, it is used when container is created without request, e.g. by scheduler
+func userOwnerSet(ctx context.Context, user *User, conf *ContainerConfiguration) error {
+	if !user.HasRole(ROLE_ADMIN, ROLE_MEMBER) {
+		return ErrPermissionDenied
+	}
+	if conf.Project != "" && !user.IsAdmin() && !user.InProject(conf.Project) {
+		return errors.New("permission denied, " + user.Username + " is not member of project " + conf.Project)
+	}
+	// network stack of host or other container is shared, so only admin can use it
+	if mode := container.NetworkMode(conf.Network); (mode.IsHost() || mode.IsContainer()) && !user.IsAdmin() {
+		return errors.New("permission denied, only admin can use network " + conf.Network)
+	}
+	conf.Owner = user.Username
+	return secretsAllowed(ctx, user, conf.Secrets)
+}
+
+// set role and projects of user
+func UserRoleUpdate(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.rbac.UserRoleUpdate()"
+		username = ctx.Param("username")
+		req      = new(UserRoleRequest)
+		user     *User
+		err      error
+	)
+
+	if err = ctx.BindJSON(req); err != nil || !roleValid(req.Role) {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, role is one of admin, member and viewer"
+		goto RESPONSE
+	}
+
+	if user, err = Users.Get(ctx, username); err != nil {
+		if err != ErrUserNotFound {
+			log.Logger.Errorf("%s error, get user %s error: %v", m, username, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get user error"
+		goto RESPONSE
+	}
+
+	user.Role, user.Projects = req.Role, req.Projects
+	if user.Projects == nil {
+		user.Projects = make([]string, 0)
+	}
+	if err = Users.Update(ctx, user); err != nil {
+		log.Logger.Errorf("%s error, update role of %s error: %v", m, username, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "update role error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("role of %s is set to %s with projects %v by %s", username, user.Role, user.Projects, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, user
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
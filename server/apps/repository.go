@@ -0,0 +1,30 @@
+package apps
+
+import (
+	"iCloud/commons"
+	"iCloud/conf"
+	"iCloud/log"
+)
+
+// use mongoDB to store deployment history, users, quotas, templates, secrets, jobs, schedules, stacks and moved containers,
+// in-process memory is used only if memoryStore is set, data is lost after restart
+func RepositoryInit() error {
+	if conf.Iconf.MemoryStore {
+		log.Logger.Info("memoryStore is set, data is kept in memory")
+		return nil
+	}
+	if err := commons.Mongo.MongoInit(); err != nil {
+		log.Logger.Errorf("apps.repository.RepositoryInit() error, connect to mongoDB error: %v", err)
+		return err
+	}
+	Deployments = NewMongoDeploymentRepository(commons.Mongo)
+	Users = NewMongoUserRepository(commons.Mongo)
+	Quotas = NewMongoQuotaRepository(commons.Mongo)
+	Templates = NewMongoTemplateRepository(commons.Mongo)
+	Secrets = NewMongoSecretRepository(commons.Mongo)
+	Jobs = NewMongoJobRepository(commons.Mongo)
+	Schedules = NewMongoScheduleRepository(commons.Mongo)
+	Stacks = NewMongoStackRepository(commons.Mongo)
+	MovedContainers = NewMongoMovedContainerRepository(commons.Mongo)
+	return nil
+}
@@ -0,0 +1,514 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"iCloud/commons"
+	"iCloud/log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var Schedules = NewMemoryScheduleRepository()
+
+// schedules are changed by handlers and by runner, so they are not updated at the same time
+var scheduleLock sync.Mutex
+
+// job submitted to queue at times of cron expression, its configuration is given or read from template when job is submitted.
+// job runs as owner of schedule, who is the user creating or updating it last
+type Schedule struct {
+	Id            string                  `json:"id" bson:"_id"`
+	Name          string                  `json:"name" bson:"name"` // name of jobs
+	Description   string                  `json:"description" bson:"description"`
+	Cron          string                  `json:"cron" bson:"cron"`
+	Template      string                  `json:"template" bson:"template"`
+	Configuration *ContainerConfiguration `json:"configuration" bson:"configuration"` // used if template is not set
+	TargetIp      string                  `json:"targetIp" bson:"targetIp"`           // host of jobs, it is chosen by strategy if it is not set
+	TargetPort    string                  `json:"targetPort" bson:"targetPort"`
+	Strategy      string                  `json:"strategy" bson:"strategy"`
+	Priority      int                     `json:"priority" bson:"priority"`
+	MaxRetries    int                     `json:"maxRetries" bson:"maxRetries"`
+	AllowOverlap  bool                    `json:"allowOverlap" bson:"allowOverlap"` // run is skipped if job of last run is not finished and overlap is not allowed
+	Enabled       bool                    `json:"enabled" bson:"enabled"`
+	Project       string                  `json:"project" bson:"project"`
+	Owner         string                  `json:"owner" bson:"owner"`
+	NextRun       int64                   `json:"nextRun" bson:"nextRun"`
+	LastRun       int64                   `json:"lastRun" bson:"lastRun"`
+	LastJob       string                  `json:"lastJob" bson:"lastJob"` // id of job submitted by last run
+	LastError     string                  `json:"lastError" bson:"lastError"`
+	CreateTime    int64                   `json:"createTime" bson:"createTime"`
+	UpdateTime    int64                   `json:"updateTime" bson:"updateTime"`
+}
+
+type ScheduleRequest struct {
+	Name          string                  `json:"name"`
+	Description   string                  `json:"description"`
+	Cron          string                  `json:"cron"`
+	Template      string                  `json:"template"`
+	Configuration *ContainerConfiguration `json:"configuration"`
+	TargetIp      string                  `json:"targetIp"`
+	TargetPort    string                  `json:"targetPort"`
+	Strategy      string                  `json:"strategy"`
+	Priority      int                     `json:"priority"`
+	MaxRetries    *int                    `json:"maxRetries"` // JOB_DEFAULT_MAX_RETRIES if it is not set
+	AllowOverlap  bool                    `json:"allowOverlap"`
+	Enabled       *bool                   `json:"enabled"` // true if it is not set
+}
+
+// deep copy, configuration of schedule is changed when job is submitted
+func (s *Schedule) copy() *Schedule {
+	schedule := *s
+	if s.Configuration != nil {
+		schedule.Configuration = new(ContainerConfiguration)
+		content, _ := json.Marshal(s.Configuration)
+		_ = json.Unmarshal(content, schedule.Configuration)
+	}
+	return &schedule
+}
+
+func (s *Schedule) allowed(user *User, permission string) bool {
+	return user.Allowed(s.Owner, s.Project, permission)
+}
+
+// time of next run after t, 0 if schedule is disabled
+func (s *Schedule) next(t time.Time) int64 {
+	if !s.Enabled {
+		return 0
+	}
+	c, err := commons.ParseCron(s.Cron)
+	if err != nil {
+		return 0
+	}
+	if next := c.Next(t); !next.IsZero() {
+		return next.Unix()
+	}
+	return 0
+}
+
+// configuration of job checked with permissions of user, it is read from template at every run
+func (s *Schedule) jobConfiguration(ctx context.Context, user *User) (conf *ContainerConfiguration, err error) {
+	if s.Template != "" {
+		if conf, err = userTemplateConfiguration(ctx, user, s.Template); err != nil {
+			return
+		}
+	} else {
+		conf = s.copy().Configuration
+	}
+	conf.ContainerName = s.Name
+
+	if err = conf.confCheck(); err != nil {
+		return
+	}
+	if err = jobConfCheck(conf); err != nil {
+		return
+	}
+	if err = userOwnerSet(ctx, user, conf); err != nil {
+		return
+	}
+	return conf, nil
+}
+
+func (req *ScheduleRequest) check() error {
+	if req.Name == "" {
+		return errors.New("name of schedule is null")
+	}
+	c, err := commons.ParseCron(req.Cron)
+	if err != nil {
+		return err
+	}
+	// e.g. "0 0 30 2 *", schedule would never run
+	if c.Next(time.Now()).IsZero() {
+		return errors.New("cron expression " + req.Cron + " never matches any time")
+	}
+	if (req.Template == "") == (req.Configuration == nil) {
+		return errors.New("one of template and configuration of schedule must be set")
+	}
+	if req.Strategy == "" {
+		req.Strategy = PLACEMENT_BIN_PACK
+	}
+	if _, exist := getPlacementStrategy(req.Strategy); !exist {
+		return errors.New("placement strategy " + req.Strategy + " dose not exist")
+	}
+	if req.MaxRetries != nil && *req.MaxRetries < 0 {
+		return errors.New("maxRetries of schedule is less than 0")
+	}
+	// fields of one container are not kept in schedule
+	if req.Configuration != nil {
+		req.Configuration.ClientIp, req.Configuration.RpcPort, req.Configuration.Owner, req.Configuration.RegistryAuth = "", "", "", nil
+	}
+	return nil
+}
+
+// set fields of schedule by request, configuration of job is checked with permissions of user
+func (s *Schedule) set(ctx context.Context, req *ScheduleRequest, user *User) (err error) {
+	var (
+		conf *ContainerConfiguration
+	)
+
+	s.Description, s.Cron, s.Template, s.Configuration = req.Description, req.Cron, req.Template, req.Configuration
+	s.TargetIp, s.TargetPort, s.Strategy, s.Priority = req.TargetIp, req.TargetPort, req.Strategy, req.Priority
+	s.MaxRetries, s.AllowOverlap, s.Enabled = commons.JOB_DEFAULT_MAX_RETRIES, req.AllowOverlap, true
+	if req.MaxRetries != nil {
+		s.MaxRetries = *req.MaxRetries
+	}
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+	s.Owner, s.UpdateTime = user.Username, time.Now().Unix()
+
+	if conf, err = s.jobConfiguration(ctx, user); err != nil {
+		return
+	}
+	s.Project = conf.Project
+	s.NextRun = s.next(time.Now())
+	return nil
+}
+
+// submit job of schedule to queue, run is skipped if job of last run is not finished and overlap is not allowed
+func scheduleRun(ctx context.Context, s *Schedule) (job *Job, err error) {
+	var (
+		m       = "apps.schedule.scheduleRun()"
+		owner   *User
+		last    *Job
+		conf    *ContainerConfiguration
+		release func()
+	)
+
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+
+	s.LastRun = time.Now().Unix()
+	if !s.AllowOverlap && s.LastJob != "" {
+		if last, err = Jobs.Get(mongoCtx, s.LastJob); err != nil && err != ErrJobNotFound {
+			log.Logger.Errorf("%s error, get last job[%s] of schedule %s error: %v", m, s.LastJob, s.Name, err)
+			return nil, errors.New("get job of last run error")
+		}
+		if last != nil && (last.Status == JOB_STATUS_PENDING || last.Status == JOB_STATUS_RUNNING) {
+			return nil, errors.New("run is skipped, job " + last.Id + " of last run is " + last.Status)
+		}
+	}
+
+	if owner, err = Users.Get(mongoCtx, s.Owner); err != nil {
+		log.Logger.Errorf("%s error, get owner %s of schedule %s error: %v", m, s.Owner, s.Name, err)
+		return nil, errors.New("get owner " + s.Owner + " of schedule error")
+	}
+	if conf, err = s.jobConfiguration(mongoCtx, owner); err != nil {
+		return
+	}
+	// container of job is created by dispatcher later, so its resources are not reserved, quota is checked again before it is started
+	if release, err = userQuotaCheck(mongoCtx, owner, conf); err != nil {
+		return
+	}
+	release()
+
+	job = newJob(conf, s.Owner)
+	job.Schedule, job.Strategy, job.TargetIp, job.TargetPort = s.Name, s.Strategy, s.TargetIp, s.TargetPort
+	job.Priority, job.MaxRetries = s.Priority, s.MaxRetries
+	if err = Jobs.Insert(mongoCtx, job); err != nil {
+		log.Logger.Errorf("%s error, insert job of schedule %s error: %v", m, s.Name, err)
+		return nil, errors.New("save job error")
+	}
+	s.LastJob = job.Id
+
+	JobDispatcher.Wake()
+	log.Logger.Infof("job %s[%s] is submitted by schedule %s", job.Name, job.Id, s.Name)
+	return job, nil
+}
+
+// record result of run, error is cleared by a successful run
+func scheduleRecord(ctx context.Context, s *Schedule, err error) {
+	if s.LastError = ""; err != nil {
+		s.LastError = time.Unix(s.LastRun, 0).Format("2006-01-02 15:04:05") + " " + err.Error()
+		log.Logger.Infof("run of schedule %s fails: %v", s.Name, err)
+	}
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	if updateErr := Schedules.Update(mongoCtx, s); updateErr != nil {
+		log.Logger.Errorf("apps.schedule.scheduleRecord() error, save schedule %s error: %v", s.Name, updateErr)
+	}
+}
+
+// submit jobs of schedules which are due, run missed when server is down is submitted once after restart
+func scheduleTick(ctx context.Context) {
+	var (
+		m         = "apps.schedule.scheduleTick()"
+		schedules []*Schedule
+		now       = time.Now()
+		err       error
+	)
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	schedules, err = Schedules.List(mongoCtx)
+	mongoCancel()
+	if err != nil {
+		log.Logger.Errorf("%s error, list schedules error: %v", m, err)
+		return
+	}
+
+	for _, s := range schedules {
+		if !s.Enabled || s.NextRun == 0 || s.NextRun > now.Unix() {
+			continue
+		}
+		_, err = scheduleRun(ctx, s)
+		s.NextRun = s.next(now)
+		scheduleRecord(ctx, s, err)
+	}
+}
+
+// check schedules every SCHEDULE_CHECK_INTERVAL
+func ScheduleWatch(ctx context.Context) {
+	ticker := time.NewTicker(commons.SCHEDULE_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scheduleTick(ctx)
+		}
+	}
+}
+
+func ScheduleCreate(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.schedule.ScheduleCreate()"
+		user     = callerUser(ctx)
+		req      = new(ScheduleRequest)
+		schedule *Schedule
+		err      error
+	)
+
+	if err = ctx.BindJSON(req); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+	if err = req.check(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	schedule = &Schedule{Id: primitive.NewObjectID().Hex(), Name: req.Name, CreateTime: time.Now().Unix()}
+	if err = schedule.set(ctx, req, user); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = Schedules.Insert(ctx, schedule); err != nil {
+		if err != ErrScheduleExist {
+			log.Logger.Errorf("%s error, insert schedule %s error: %v", m, req.Name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "create schedule error: "+err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("schedule %s[%s] is created by %s", schedule.Name, schedule.Cron, user.Username)
+	rsp["ErrorCode"], rsp["Data"] = 0, schedule
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// schedules which can be read by caller
+func ScheduleList(ctx *gin.Context) {
+	var (
+		rsp       = make(gin.H)
+		m         = "apps.schedule.ScheduleList()"
+		user      = callerUser(ctx)
+		schedules []*Schedule
+		visible   = make([]*Schedule, 0)
+		err       error
+	)
+
+	if schedules, err = Schedules.List(ctx); err != nil {
+		log.Logger.Errorf("%s error, list schedules error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list schedules error"
+		goto RESPONSE
+	}
+
+	for _, s := range schedules {
+		if s.allowed(user, PERMISSION_READ) {
+			visible = append(visible, s)
+		}
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, visible
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// schedule which can be accessed by caller with permission
+func scheduleOf(ctx *gin.Context, name, permission string) (schedule *Schedule, err error) {
+	if schedule, err = Schedules.Get(ctx, name); err != nil {
+		if err != ErrScheduleNotFound {
+			log.Logger.Errorf("apps.schedule.scheduleOf() error, get schedule %s error: %v", name, err)
+		}
+		return nil, errors.New("get schedule error")
+	}
+	if !schedule.allowed(callerUser(ctx), permission) {
+		return nil, ErrPermissionDenied
+	}
+	return
+}
+
+func ScheduleDetail(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		schedule *Schedule
+		err      error
+	)
+
+	if schedule, err = scheduleOf(ctx, ctx.Param("name"), PERMISSION_READ); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, schedule
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// replace schedule by request, name can not be changed, caller becomes owner of schedule
+func ScheduleUpdate(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.schedule.ScheduleUpdate()"
+		name     = ctx.Param("name")
+		user     = callerUser(ctx)
+		req      = new(ScheduleRequest)
+		schedule *Schedule
+		err      error
+	)
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	if schedule, err = scheduleOf(ctx, name, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = ctx.BindJSON(req); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+	req.Name = name
+	if err = req.check(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	if err = schedule.set(ctx, req, user); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = Schedules.Update(ctx, schedule); err != nil {
+		log.Logger.Errorf("%s error, update schedule %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "update schedule error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("schedule %s[%s] is updated by %s", name, schedule.Cron, user.Username)
+	rsp["ErrorCode"], rsp["Data"] = 0, schedule
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// jobs submitted by schedule are kept as its history
+func ScheduleDelete(ctx *gin.Context) {
+	var (
+		rsp  = make(gin.H)
+		m    = "apps.schedule.ScheduleDelete()"
+		name = ctx.Param("name")
+		err  error
+	)
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	if _, err = scheduleOf(ctx, name, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = Schedules.Delete(ctx, name); err != nil {
+		log.Logger.Errorf("%s error, delete schedule %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "delete schedule error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("schedule %s is deleted by %s", name, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, struct{}{}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// submit job of schedule now, time of next run is not changed
+func ScheduleRun(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		schedule *Schedule
+		job      *Job
+		err      error
+	)
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	if schedule, err = scheduleOf(ctx, ctx.Param("name"), PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	job, err = scheduleRun(ctx, schedule)
+	scheduleRecord(ctx, schedule, err)
+	if err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, job
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// jobs submitted by schedule, newest first, "limit" in url is 20 by default
+func ScheduleHistory(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		m     = "apps.schedule.ScheduleHistory()"
+		name  = ctx.Param("name")
+		limit int64
+		jobs  []*Job
+		err   error
+	)
+
+	if limit, err = strconv.ParseInt(ctx.DefaultQuery("limit", "20"), 10, 64); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "param error, limit is not integer"
+		goto RESPONSE
+	}
+	if _, err = scheduleOf(ctx, name, PERMISSION_READ); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if jobs, err = Jobs.Find(ctx, &JobFilter{Schedule: name, Limit: limit}); err != nil {
+		log.Logger.Errorf("%s error, find jobs of schedule %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "find jobs of schedule error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, jobs
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
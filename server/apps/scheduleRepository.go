@@ -0,0 +1,157 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"sort"
+	"sync"
+)
+
+const MONGO_COLLECTION_SCHEDULE = "schedules"
+
+var (
+	ErrScheduleNotFound = errors.New("schedule dose not exist")
+	ErrScheduleExist    = errors.New("schedule exists already")
+)
+
+// storage of scheduled jobs, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type ScheduleRepository interface {
+	Insert(ctx context.Context, schedule *Schedule) error
+	Get(ctx context.Context, name string) (*Schedule, error)
+	List(ctx context.Context) ([]*Schedule, error)
+	Update(ctx context.Context, schedule *Schedule) error
+	Delete(ctx context.Context, name string) error
+}
+
+type mongoScheduleRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoScheduleRepository(m *commons.MONGO) ScheduleRepository {
+	return &mongoScheduleRepository{collection: m.Collection(MONGO_COLLECTION_SCHEDULE)}
+}
+
+func (r *mongoScheduleRepository) Insert(ctx context.Context, schedule *Schedule) (err error) {
+	if _, err = r.Get(ctx, schedule.Name); err == nil {
+		return ErrScheduleExist
+	} else if err != ErrScheduleNotFound {
+		return
+	}
+	_, err = r.collection.InsertOne(ctx, schedule)
+	return
+}
+
+func (r *mongoScheduleRepository) Get(ctx context.Context, name string) (schedule *Schedule, err error) {
+	schedule = new(Schedule)
+	if err = r.collection.FindOne(ctx, bson.M{"name": name}).Decode(schedule); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrScheduleNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoScheduleRepository) List(ctx context.Context) (schedules []*Schedule, err error) {
+	var (
+		cursor *mongo.Cursor
+	)
+
+	if cursor, err = r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}})); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	schedules = make([]*Schedule, 0)
+	err = cursor.All(ctx, &schedules)
+	return
+}
+
+func (r *mongoScheduleRepository) Update(ctx context.Context, schedule *Schedule) (err error) {
+	var (
+		result *mongo.UpdateResult
+	)
+	if result, err = r.collection.ReplaceOne(ctx, bson.M{"_id": schedule.Id}, schedule); err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return ErrScheduleNotFound
+	}
+	return
+}
+
+func (r *mongoScheduleRepository) Delete(ctx context.Context, name string) (err error) {
+	var (
+		result *mongo.DeleteResult
+	)
+	if result, err = r.collection.DeleteOne(ctx, bson.M{"name": name}); err != nil {
+		return
+	}
+	if result.DeletedCount == 0 {
+		return ErrScheduleNotFound
+	}
+	return
+}
+
+type memoryScheduleRepository struct {
+	mu        sync.RWMutex
+	schedules map[string]*Schedule // key is name
+}
+
+func NewMemoryScheduleRepository() ScheduleRepository {
+	return &memoryScheduleRepository{schedules: make(map[string]*Schedule)}
+}
+
+func (r *memoryScheduleRepository) Insert(ctx context.Context, schedule *Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.schedules[schedule.Name]; exist {
+		return ErrScheduleExist
+	}
+	r.schedules[schedule.Name] = schedule.copy()
+	return nil
+}
+
+func (r *memoryScheduleRepository) Get(ctx context.Context, name string) (*Schedule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if s, exist := r.schedules[name]; exist {
+		return s.copy(), nil
+	}
+	return nil, ErrScheduleNotFound
+}
+
+func (r *memoryScheduleRepository) List(ctx context.Context) ([]*Schedule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schedules := make([]*Schedule, 0, len(r.schedules))
+	for _, s := range r.schedules {
+		schedules = append(schedules, s.copy())
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules, nil
+}
+
+func (r *memoryScheduleRepository) Update(ctx context.Context, schedule *Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, exist := r.schedules[schedule.Name]; !exist || s.Id != schedule.Id {
+		return ErrScheduleNotFound
+	}
+	r.schedules[schedule.Name] = schedule.copy()
+	return nil
+}
+
+func (r *memoryScheduleRepository) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.schedules[name]; !exist {
+		return ErrScheduleNotFound
+	}
+	delete(r.schedules, name)
+	return nil
+}
@@ -0,0 +1,323 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"iCloud/commons"
+	"iCloud/log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	PLACEMENT_BIN_PACK     = "binPack"
+	PLACEMENT_SPREAD       = "spread"
+	PLACEMENT_LEAST_LOADED = "leastLoaded"
+)
+
+var (
+	placementStrategies   = make(map[string]PlacementStrategy)
+	placementStrategiesMu sync.RWMutex
+	placementReserved     = newPlacementReservation()
+)
+
+// resource of one host which can be used by scheduler, resource reserved by recent placement is subtracted
+type HostCandidate struct {
+	Host     *commons.Host
+	FreeMem  float64 // GB
+	FreeCpu  float64 // cores
+	CpuUsage float64 // percent
+}
+
+type placementReserve struct {
+	cpu, mem float64
+	time     int64
+}
+
+// resource of containers placed on hosts recently, it is not in heartbeat of host yet.
+// it is reserved for PLACEMENT_RESERVE_TIMEOUT, so concurrent placements do not choose the same free resource.
+// mu is held by caller of add and get
+type placementReservation struct {
+	mu       sync.Mutex
+	reserved map[string][]placementReserve // key is ip of host
+}
+
+func newPlacementReservation() *placementReservation {
+	return &placementReservation{reserved: make(map[string][]placementReserve)}
+}
+
+func (r *placementReservation) add(ip string, cpu, mem float64, now int64) {
+	r.reserved[ip] = append(r.reserved[ip], placementReserve{cpu: cpu, mem: mem, time: now})
+}
+
+// expired reserves are removed
+func (r *placementReservation) get(ip string, now int64) (cpu, mem float64) {
+	reserves := r.reserved[ip][:0]
+	for _, reserve := range r.reserved[ip] {
+		if now-reserve.time >= commons.PLACEMENT_RESERVE_TIMEOUT {
+			continue
+		}
+		reserves = append(reserves, reserve)
+		cpu, mem = cpu+reserve.cpu, mem+reserve.mem
+	}
+	if len(reserves) == 0 {
+		delete(r.reserved, ip)
+	} else {
+		r.reserved[ip] = reserves
+	}
+	return
+}
+
+// PlacementStrategy scores hosts which can hold the container, the host with the highest score is chosen
+type PlacementStrategy interface {
+	Score(candidate *HostCandidate, maxCpu, maxMem float64) float64
+}
+
+// strategy which breaks tie of scores, hosts with equal score are compared by it before ip
+type PlacementTieBreaker interface {
+	TieBreak(candidate *HostCandidate, maxCpu, maxMem float64) float64
+}
+
+// put container to the host whose resource is used most, to keep other hosts free for big container.
+// free cpu after placement is compared first, and free memory when free cpu is equal
+type binPackStrategy struct{}
+
+// free cpu is rounded to 0.01 core, it is computed from usage in percent
+func (s binPackStrategy) Score(candidate *HostCandidate, maxCpu, maxMem float64) float64 {
+	return -math.Round((candidate.FreeCpu-maxCpu)*100) / 100
+}
+
+func (s binPackStrategy) TieBreak(candidate *HostCandidate, maxCpu, maxMem float64) float64 {
+	return -(candidate.FreeMem - maxMem)
+}
+
+// put container to the host which has most free memory and cpu after placement
+type spreadStrategy struct{}
+
+func (s spreadStrategy) Score(candidate *HostCandidate, maxCpu, maxMem float64) float64 {
+	return (candidate.FreeMem - maxMem) + (candidate.FreeCpu - maxCpu)
+}
+
+// put container to the host whose cpu usage is lowest
+type leastLoadedStrategy struct{}
+
+func (s leastLoadedStrategy) Score(candidate *HostCandidate, maxCpu, maxMem float64) float64 {
+	return -candidate.CpuUsage
+}
+
+type PlacementDecision struct {
+	Strategy string            `json:"strategy"`
+	Host     *commons.Host     `json:"host"`
+	Reason   string            `json:"reason"`
+	Rejected map[string]string `json:"rejected"` // ip: reason
+}
+
+func init() {
+	RegisterPlacementStrategy(PLACEMENT_BIN_PACK, binPackStrategy{})
+	RegisterPlacementStrategy(PLACEMENT_SPREAD, spreadStrategy{})
+	RegisterPlacementStrategy(PLACEMENT_LEAST_LOADED, leastLoadedStrategy{})
+}
+
+func RegisterPlacementStrategy(name string, strategy PlacementStrategy) {
+	placementStrategiesMu.Lock()
+	defer placementStrategiesMu.Unlock()
+	placementStrategies[name] = strategy
+}
+
+func getPlacementStrategy(name string) (strategy PlacementStrategy, exist bool) {
+	placementStrategiesMu.RLock()
+	defer placementStrategiesMu.RUnlock()
+	strategy, exist = placementStrategies[name]
+	return
+}
+
+// check whether host can hold the container, return reason if it can not.
+// reservedCpu and reservedMem are used by containers placed on host recently
+func hostCandidateCheck(host *commons.Host, maxCpu, maxMem, reservedCpu, reservedMem float64, now int64) (candidate *HostCandidate, reason string) {
+	var (
+		err error
+	)
+	candidate = &HostCandidate{Host: host}
+
+	if now-host.Heartbeat > commons.HOST_HEARTBEAT_TIMEOUT {
+		return nil, fmt.Sprintf("heartbeat is stale, last heartbeat %d seconds ago", now-host.Heartbeat)
+	}
+
+	if candidate.FreeMem, err = strconv.ParseFloat(host.FreeMem, 64); err != nil {
+		return nil, "free memory of host is unknown"
+	}
+	if candidate.FreeMem -= reservedMem; candidate.FreeMem < maxMem {
+		return nil, fmt.Sprintf("free memory %.2fGB is less than %.2fGB", candidate.FreeMem, maxMem)
+	}
+
+	if candidate.CpuUsage, err = strconv.ParseFloat(host.CpuUsage, 64); err != nil {
+		return nil, "cpu usage of host is unknown"
+	}
+	candidate.FreeCpu = float64(host.CpuCores)*(1-candidate.CpuUsage/100) - reservedCpu
+	if candidate.FreeCpu < maxCpu {
+		return nil, fmt.Sprintf("free cpu %.2f cores is less than %.2f", candidate.FreeCpu, maxCpu)
+	}
+
+	if host.FreeDisk < commons.HOST_MIN_FREE_DISK {
+		return nil, fmt.Sprintf("free disk %dGB is less than %dGB", host.FreeDisk, commons.HOST_MIN_FREE_DISK)
+	}
+
+	return candidate, ""
+}
+
+// choose one host from hosts for the container by strategy, resource of the container is reserved on the host
+func placeContainer(hosts []*commons.Host, conf *ContainerConfiguration, strategyName string) (decision *PlacementDecision, err error) {
+	var (
+		strategy       PlacementStrategy
+		exist          bool
+		maxCpu, maxMem float64
+		now            = time.Now().Unix()
+		candidates     = make([]*HostCandidate, 0, len(hosts))
+		scores         = make(map[string]float64)
+		tieBreaks      = make(map[string]float64)
+	)
+
+	if strategy, exist = getPlacementStrategy(strategyName); !exist {
+		return nil, errors.New("placement strategy " + strategyName + " dose not exist")
+	}
+
+	if maxCpu, err = strconv.ParseFloat(conf.MaxCpu, 64); err != nil {
+		return nil, errors.New("type of MaxCpu is not number")
+	}
+	if maxMem, err = strconv.ParseFloat(conf.MaxMem, 64); err != nil {
+		return nil, errors.New("type of MaxMem is not number")
+	}
+
+	decision = &PlacementDecision{Strategy: strategyName, Rejected: make(map[string]string)}
+
+	// check and reserve of hosts are not interleaved by other placement
+	placementReserved.mu.Lock()
+	defer placementReserved.mu.Unlock()
+
+	for _, host := range hosts {
+		reservedCpu, reservedMem := placementReserved.get(host.Ip, now)
+		candidate, reason := hostCandidateCheck(host, maxCpu, maxMem, reservedCpu, reservedMem, now)
+		if candidate == nil {
+			decision.Rejected[host.Ip] = reason
+			continue
+		}
+		scores[host.Ip] = strategy.Score(candidate, maxCpu, maxMem)
+		if tieBreaker, ok := strategy.(PlacementTieBreaker); ok {
+			tieBreaks[host.Ip] = tieBreaker.TieBreak(candidate, maxCpu, maxMem)
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	if len(candidates) == 0 {
+		return decision, errors.New("no host has enough resource for the container")
+	}
+
+	// sort by tie break of strategy and then ip when score is equal, to make placement stable
+	sort.Slice(candidates, func(i, j int) bool {
+		ipi, ipj := candidates[i].Host.Ip, candidates[j].Host.Ip
+		if scores[ipi] != scores[ipj] {
+			return scores[ipi] > scores[ipj]
+		}
+		if tieBreaks[ipi] != tieBreaks[ipj] {
+			return tieBreaks[ipi] > tieBreaks[ipj]
+		}
+		return ipi < ipj
+	})
+
+	decision.Host = candidates[0].Host
+	placementReserved.add(decision.Host.Ip, maxCpu, maxMem, now)
+	decision.Reason = fmt.Sprintf("highest %s score %.2f in %d candidate hosts", strategyName, scores[decision.Host.Ip], len(candidates))
+	for _, candidate := range candidates[1:] {
+		score := scores[candidate.Host.Ip]
+		if score == scores[decision.Host.Ip] {
+			decision.Rejected[candidate.Host.Ip] = fmt.Sprintf("%s score %.2f is equal to %s, which is chosen by tie break or ip", strategyName, score, decision.Host.Ip)
+		} else {
+			decision.Rejected[candidate.Host.Ip] = fmt.Sprintf("%s score %.2f is lower than %s", strategyName, score, decision.Host.Ip)
+		}
+	}
+
+	return decision, nil
+}
+
+// create and start container on the host chosen by scheduler, ip of host is not required
+func ContainerSchedule(ctx *gin.Context) {
+	var (
+		err           error
+		m             = "apps.scheduler.ContainerSchedule()"
+		containerConf = new(ContainerConfiguration)
+		rsp           = make(gin.H)
+		hosts         []*commons.Host
+		decision      *PlacementDecision
+		cli           *client.Client
+		deployment    *Deployment
+		release       func()
+		strategyName  = ctx.DefaultQuery("strategy", PLACEMENT_BIN_PACK)
+	)
+
+	if err = ctx.BindJSON(containerConf); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+
+	if err = containerConf.confCheck(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerOwnerSet(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if release, err = containerQuotaCheck(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	defer release()
+
+	hosts, _ = HostRegistry.List()
+
+	if decision, err = placeContainer(hosts, containerConf, strategyName); err != nil {
+		log.Logger.Errorf("%s error, place container %s error: %v", m, containerConf.ContainerName, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, gin.H{"error": err.Error(), "placement": decision}
+		goto RESPONSE
+	}
+
+	containerConf.ClientIp, containerConf.RpcPort = decision.Host.Ip, decision.Host.GrpcPort
+
+	if cli, err = DockerClientPool.Get(decision.Host.Ip, decision.Host.ApiPort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, gin.H{"error": "connect to remote docker api error: " + err.Error(), "placement": decision}
+		goto RESPONSE
+	}
+
+	deployment = newDeployment(ctx, DEPLOY_ACTION_CREATE, decision.Host.Ip, decision.Host.ApiPort)
+	deployment.Configuration = containerConf
+	if deployment.ContainerId, err = createContainer(cli, containerConf); err == nil {
+		// container which fails to start is removed, it is not left on host chosen by scheduler
+		if err = startContainer(deployment.ContainerId, cli); err != nil {
+			if rmErr := cli.ContainerRemove(context.TODO(), deployment.ContainerId, types.ContainerRemoveOptions{Force: true}); rmErr != nil {
+				log.Logger.Errorf("%s error, remove container[%s] which fails to start error: %v", m, deployment.ContainerId, rmErr)
+			}
+		}
+	}
+	deployment.finish(err)
+	if err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, gin.H{"error": err.Error(), "placement": decision}
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("container %s[%s] is placed on %s by %s", containerConf.ContainerName, deployment.ContainerId, decision.Host.Ip, strategyName)
+	rsp["ErrorCode"], rsp["Data"] = 0, gin.H{"containerId": deployment.ContainerId, "placement": decision}
+
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
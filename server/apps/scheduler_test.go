@@ -0,0 +1,122 @@
+package apps
+
+import (
+	"iCloud/commons"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hostOf(ip string, cores int, cpuUsage, freeMem string, heartbeat int64) *commons.Host {
+	return &commons.Host{
+		Ip:        ip,
+		ApiPort:   "2375",
+		CpuCores:  cores,
+		CpuUsage:  cpuUsage,
+		FreeMem:   freeMem,
+		FreeDisk:  commons.HOST_MIN_FREE_DISK,
+		Heartbeat: heartbeat,
+	}
+}
+
+func TestPlaceContainer(t *testing.T) {
+	defer func() { placementReserved = newPlacementReservation() }()
+
+	now := time.Now().Unix()
+	hosts := []*commons.Host{
+		hostOf("10.0.0.1", 8, "50", "4", now),   // 4 free cores, 4GB
+		hostOf("10.0.0.2", 16, "10", "32", now), // 14.4 free cores, 32GB
+		hostOf("10.0.0.3", 4, "5", "8", now),    // 3.8 free cores, 8GB
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		hosts    []*commons.Host
+		cpu, mem string
+		want     string // ip of chosen host, empty if no host is chosen
+		rejected map[string]string
+	}{
+		{"bin pack chooses least free cpu", PLACEMENT_BIN_PACK, hosts, "1", "2", "10.0.0.3", map[string]string{"10.0.0.1": "lower than 10.0.0.3"}},
+		{"spread chooses most free resource", PLACEMENT_SPREAD, hosts, "1", "2", "10.0.0.2", nil},
+		{"least loaded chooses lowest cpu usage", PLACEMENT_LEAST_LOADED, hosts, "1", "2", "10.0.0.3", nil},
+		{"memory is not enough", PLACEMENT_BIN_PACK, hosts, "1", "6", "10.0.0.3", map[string]string{"10.0.0.1": "free memory"}},
+		{"bin pack chooses least free memory when free cpu is equal", PLACEMENT_BIN_PACK, []*commons.Host{
+			hostOf("10.0.0.1", 8, "50", "16", now),
+			hostOf("10.0.0.2", 4, "0", "8", now),
+			hostOf("10.0.0.3", 16, "75", "12", now),
+		}, "1", "2", "10.0.0.2", map[string]string{"10.0.0.1": "equal to 10.0.0.2", "10.0.0.3": "equal to 10.0.0.2"}},
+		{"bin pack ranks free cpu before free memory", PLACEMENT_BIN_PACK, []*commons.Host{
+			hostOf("10.0.0.1", 8, "85", "64", now),
+			hostOf("10.0.0.2", 8, "10", "4", now),
+		}, "1", "2", "10.0.0.1", nil},
+		{"free cpu is used, not total cores", PLACEMENT_BIN_PACK, hosts, "5", "2", "10.0.0.2", map[string]string{"10.0.0.1": "free cpu", "10.0.0.3": "free cpu"}},
+		{"no host is big enough", PLACEMENT_SPREAD, hosts, "16", "2", "", map[string]string{"10.0.0.2": "free cpu"}},
+		{"stale heartbeat", PLACEMENT_LEAST_LOADED, []*commons.Host{
+			hostOf("10.0.0.1", 8, "1", "8", now-commons.HOST_HEARTBEAT_TIMEOUT-1),
+			hostOf("10.0.0.2", 8, "20", "8", now),
+		}, "1", "1", "10.0.0.2", map[string]string{"10.0.0.1": "heartbeat is stale"}},
+		{"unknown cpu usage", PLACEMENT_SPREAD, []*commons.Host{
+			hostOf("10.0.0.1", 64, "", "64", now),
+			hostOf("10.0.0.2", 8, "20", "8", now),
+		}, "1", "1", "10.0.0.2", map[string]string{"10.0.0.1": "cpu usage of host is unknown"}},
+		{"equal score in order of ip", PLACEMENT_SPREAD, []*commons.Host{
+			hostOf("10.0.0.2", 8, "0", "8", now),
+			hostOf("10.0.0.1", 8, "0", "8", now),
+		}, "1", "1", "10.0.0.1", map[string]string{"10.0.0.2": "equal to 10.0.0.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placementReserved = newPlacementReservation()
+
+			decision, err := placeContainer(tt.hosts, &ContainerConfiguration{MaxCpu: tt.cpu, MaxMem: tt.mem}, tt.strategy)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("placeContainer() chooses %s, want error", decision.Host.Ip)
+				}
+			} else if err != nil {
+				t.Fatalf("placeContainer() error: %v", err)
+			} else if decision.Host.Ip != tt.want {
+				t.Fatalf("placeContainer() chooses %s, want %s", decision.Host.Ip, tt.want)
+			}
+
+			for ip, reason := range tt.rejected {
+				if !strings.Contains(decision.Rejected[ip], reason) {
+					t.Errorf("rejected reason of %s = %q, want %q", ip, decision.Rejected[ip], reason)
+				}
+			}
+		})
+	}
+}
+
+func TestPlaceContainerReserve(t *testing.T) {
+	placementReserved = newPlacementReservation()
+	defer func() { placementReserved = newPlacementReservation() }()
+
+	now := time.Now().Unix()
+	hosts := []*commons.Host{
+		hostOf("10.0.0.1", 4, "0", "16", now),
+		hostOf("10.0.0.2", 4, "0", "8", now),
+	}
+	conf := &ContainerConfiguration{MaxCpu: "3", MaxMem: "2"}
+
+	// heartbeat of host is not changed, so the second container does not fit in cpu reserved by the first one
+	for i, want := range []string{"10.0.0.1", "10.0.0.2"} {
+		decision, err := placeContainer(hosts, conf, PLACEMENT_SPREAD)
+		if err != nil {
+			t.Fatalf("placement %d error: %v", i, err)
+		}
+		if decision.Host.Ip != want {
+			t.Fatalf("placement %d chooses %s, want %s", i, decision.Host.Ip, want)
+		}
+	}
+	if decision, err := placeContainer(hosts, conf, PLACEMENT_SPREAD); err == nil {
+		t.Fatalf("third placement chooses %s, want error", decision.Host.Ip)
+	}
+
+	// reserves expire
+	if cpu, mem := placementReserved.get("10.0.0.1", now+commons.PLACEMENT_RESERVE_TIMEOUT); cpu != 0 || mem != 0 {
+		t.Errorf("reserved after timeout = %.2f cpu %.2fGB, want 0", cpu, mem)
+	}
+}
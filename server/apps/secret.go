@@ -0,0 +1,337 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"iCloud/commons"
+	"iCloud/conf"
+	"iCloud/log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	LABEL_PREFIX  = "iCloud."        // labels of iCloud, they can not be set by user
+	LABEL_SECRETS = "iCloud.secrets" // "ENV=secret,..." env of container which is injected from secret
+
+	SECRET_MASK = "******"
+)
+
+var Secrets = NewMemorySecretRepository()
+
+// value is encrypted by key in configuration, it is never returned by api
+type Secret struct {
+	Id         string `json:"id" bson:"_id"`
+	Name       string `json:"name" bson:"name"`
+	Project    string `json:"project" bson:"project"`
+	Owner      string `json:"owner" bson:"owner"`
+	Value      string `json:"-" bson:"value"`
+	CreateTime int64  `json:"createTime" bson:"createTime"`
+	UpdateTime int64  `json:"updateTime" bson:"updateTime"`
+}
+
+// secret injected to container as env
+type SecretRef struct {
+	Name string `json:"name" bson:"name"`
+	Env  string `json:"env" bson:"env"`
+}
+
+type SecretRequest struct {
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	Value   string `json:"value"`
+}
+
+func secretKey() ([]byte, error) {
+	if conf.Iconf.Auth.SecretKey == "" {
+		return nil, errors.New("secretKey is not set in configuration, secrets can not be used")
+	}
+	return commons.AesKey(conf.Iconf.Auth.SecretKey), nil
+}
+
+func (s *Secret) allowed(user *User, permission string) bool {
+	return user.Allowed(s.Owner, s.Project, permission)
+}
+
+func (s *Secret) valueSet(value string) (err error) {
+	var (
+		key []byte
+	)
+	if key, err = secretKey(); err != nil {
+		return
+	}
+	s.Value, err = commons.AesEncrypt(key, []byte(value))
+	return
+}
+
+// secrets referenced by container must be readable by caller
+func secretsAllowed(ctx context.Context, user *User, refs []SecretRef) (err error) {
+	var (
+		m      = "apps.secret.secretsAllowed()"
+		secret *Secret
+	)
+
+	if len(refs) == 0 {
+		return nil
+	}
+	if _, err = secretKey(); err != nil {
+		return
+	}
+
+	for _, ref := range refs {
+		if secret, err = Secrets.Get(ctx, ref.Name); err != nil {
+			if err != ErrSecretNotFound {
+				log.Logger.Errorf("%s error, get secret %s error: %v", m, ref.Name, err)
+				return errors.New("get secret error")
+			}
+			return errors.New("secret " + ref.Name + " dose not exist")
+		}
+		if !secret.allowed(user, PERMISSION_READ) {
+			return errors.New("permission denied, secret " + ref.Name + " can not be used by " + user.Username)
+		}
+	}
+	return nil
+}
+
+// "ENV=value" of secrets, values are decrypted
+func secretEnv(ctx context.Context, refs []SecretRef) (env []string, err error) {
+	var (
+		m      = "apps.secret.secretEnv()"
+		key    []byte
+		secret *Secret
+		value  []byte
+	)
+
+	if len(refs) == 0 {
+		return
+	}
+	if key, err = secretKey(); err != nil {
+		return
+	}
+
+	env = make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if secret, err = Secrets.Get(ctx, ref.Name); err != nil {
+			log.Logger.Errorf("%s error, get secret %s error: %v", m, ref.Name, err)
+			return nil, errors.New("get secret " + ref.Name + " error")
+		}
+		if value, err = commons.AesDecrypt(key, secret.Value); err != nil {
+			log.Logger.Errorf("%s error, decrypt secret %s error: %v", m, ref.Name, err)
+			return nil, errors.New("decrypt secret " + ref.Name + " error")
+		}
+		env = append(env, ref.Env+"="+string(value))
+	}
+	return
+}
+
+func secretLabel(refs []SecretRef) string {
+	pairs := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		pairs = append(pairs, ref.Env+"="+ref.Name)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func secretRefsFromLabel(label string) (refs []SecretRef) {
+	refs = make([]SecretRef, 0)
+	for _, pair := range strings.Split(label, ",") {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+			refs = append(refs, SecretRef{Env: kv[0], Name: kv[1]})
+		}
+	}
+	return
+}
+
+// values of env from secrets are replaced by mask in inspect of container
+func containerEnvMask(detail *types.ContainerJSON) {
+	if detail.Config == nil || detail.Config.Labels[LABEL_SECRETS] == "" {
+		return
+	}
+
+	masked := make(map[string]bool)
+	for _, ref := range secretRefsFromLabel(detail.Config.Labels[LABEL_SECRETS]) {
+		masked[ref.Env] = true
+	}
+	for i, env := range detail.Config.Env {
+		if kv := strings.SplitN(env, "=", 2); masked[kv[0]] {
+			detail.Config.Env[i] = kv[0] + "=" + SECRET_MASK
+		}
+	}
+}
+
+// env of configuration and secrets in "KEY=value", sorted by key
+func containerEnv(conf *ContainerConfiguration) (env []string, err error) {
+	var (
+		injected []string
+	)
+
+	env = make([]string, 0, len(conf.Env)+len(conf.Secrets))
+	for k, v := range conf.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+
+	if injected, err = secretEnv(context.TODO(), conf.Secrets); err != nil {
+		return
+	}
+	return append(env, injected...), nil
+}
+
+func SecretCreate(ctx *gin.Context) {
+	var (
+		rsp    = make(gin.H)
+		m      = "apps.secret.SecretCreate()"
+		user   = callerUser(ctx)
+		req    = new(SecretRequest)
+		secret *Secret
+		err    error
+	)
+
+	if err = ctx.BindJSON(req); err != nil || req.Name == "" || req.Value == "" {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, name and value of secret are required"
+		goto RESPONSE
+	}
+	if req.Project != "" && !user.IsAdmin() && !user.InProject(req.Project) {
+		rsp["ErrorCode"], rsp["Data"] = 1, "permission denied, caller is not member of project "+req.Project
+		goto RESPONSE
+	}
+
+	secret = &Secret{
+		Id:         primitive.NewObjectID().Hex(),
+		Name:       req.Name,
+		Project:    req.Project,
+		Owner:      user.Username,
+		CreateTime: time.Now().Unix(),
+		UpdateTime: time.Now().Unix(),
+	}
+	if err = secret.valueSet(req.Value); err != nil {
+		log.Logger.Errorf("%s error, encrypt secret %s error: %v", m, req.Name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "encrypt secret error: "+err.Error()
+		goto RESPONSE
+	}
+	if err = Secrets.Insert(ctx, secret); err != nil {
+		if err != ErrSecretExist {
+			log.Logger.Errorf("%s error, insert secret %s error: %v", m, req.Name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "create secret error: "+err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("secret %s is created by %s", secret.Name, user.Username)
+	rsp["ErrorCode"], rsp["Data"] = 0, secret
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// secrets which can be read by caller, values are not returned
+func SecretList(ctx *gin.Context) {
+	var (
+		rsp     = make(gin.H)
+		m       = "apps.secret.SecretList()"
+		user    = callerUser(ctx)
+		secrets []*Secret
+		visible = make([]*Secret, 0)
+		err     error
+	)
+
+	if secrets, err = Secrets.List(ctx); err != nil {
+		log.Logger.Errorf("%s error, list secrets error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list secrets error"
+		goto RESPONSE
+	}
+
+	for _, s := range secrets {
+		if s.allowed(user, PERMISSION_READ) {
+			visible = append(visible, s)
+		}
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, visible
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// set value of secret, containers created before get the new value only after they are created again
+func SecretUpdate(ctx *gin.Context) {
+	var (
+		rsp    = make(gin.H)
+		m      = "apps.secret.SecretUpdate()"
+		name   = ctx.Param("name")
+		user   = callerUser(ctx)
+		req    = new(SecretRequest)
+		secret *Secret
+		err    error
+	)
+
+	if err = ctx.BindJSON(req); err != nil || req.Value == "" {
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error, value of secret is required"
+		goto RESPONSE
+	}
+
+	if secret, err = Secrets.Get(ctx, name); err != nil {
+		if err != ErrSecretNotFound {
+			log.Logger.Errorf("%s error, get secret %s error: %v", m, name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get secret error"
+		goto RESPONSE
+	}
+	if !secret.allowed(user, PERMISSION_WRITE) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	if err = secret.valueSet(req.Value); err != nil {
+		log.Logger.Errorf("%s error, encrypt secret %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "encrypt secret error: "+err.Error()
+		goto RESPONSE
+	}
+	secret.UpdateTime = time.Now().Unix()
+	if err = Secrets.Update(ctx, secret); err != nil {
+		log.Logger.Errorf("%s error, update secret %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "update secret error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("secret %s is updated by %s", name, user.Username)
+	rsp["ErrorCode"], rsp["Data"] = 0, secret
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func SecretDelete(ctx *gin.Context) {
+	var (
+		rsp    = make(gin.H)
+		m      = "apps.secret.SecretDelete()"
+		name   = ctx.Param("name")
+		secret *Secret
+		err    error
+	)
+
+	if secret, err = Secrets.Get(ctx, name); err != nil {
+		if err != ErrSecretNotFound {
+			log.Logger.Errorf("%s error, get secret %s error: %v", m, name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get secret error"
+		goto RESPONSE
+	}
+	if !secret.allowed(callerUser(ctx), PERMISSION_WRITE) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	if err = Secrets.Delete(ctx, name); err != nil {
+		log.Logger.Errorf("%s error, delete secret %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "delete secret error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("secret %s is deleted by %s", name, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, struct{}{}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
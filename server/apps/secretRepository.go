@@ -0,0 +1,161 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"sort"
+	"sync"
+)
+
+const MONGO_COLLECTION_SECRET = "secrets"
+
+var (
+	ErrSecretNotFound = errors.New("secret dose not exist")
+	ErrSecretExist    = errors.New("secret exists already")
+)
+
+// storage of encrypted secrets of containers, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type SecretRepository interface {
+	Insert(ctx context.Context, secret *Secret) error
+	Get(ctx context.Context, name string) (*Secret, error)
+	List(ctx context.Context) ([]*Secret, error)
+	Update(ctx context.Context, secret *Secret) error
+	Delete(ctx context.Context, name string) error
+}
+
+type mongoSecretRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoSecretRepository(m *commons.MONGO) SecretRepository {
+	return &mongoSecretRepository{collection: m.Collection(MONGO_COLLECTION_SECRET)}
+}
+
+func (r *mongoSecretRepository) Insert(ctx context.Context, secret *Secret) (err error) {
+	if _, err = r.Get(ctx, secret.Name); err == nil {
+		return ErrSecretExist
+	} else if err != ErrSecretNotFound {
+		return
+	}
+	_, err = r.collection.InsertOne(ctx, secret)
+	return
+}
+
+func (r *mongoSecretRepository) Get(ctx context.Context, name string) (secret *Secret, err error) {
+	secret = new(Secret)
+	if err = r.collection.FindOne(ctx, bson.M{"name": name}).Decode(secret); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrSecretNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoSecretRepository) List(ctx context.Context) (secrets []*Secret, err error) {
+	var (
+		cursor *mongo.Cursor
+	)
+
+	if cursor, err = r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}})); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	secrets = make([]*Secret, 0)
+	err = cursor.All(ctx, &secrets)
+	return
+}
+
+func (r *mongoSecretRepository) Update(ctx context.Context, secret *Secret) (err error) {
+	var (
+		result *mongo.UpdateResult
+	)
+	if result, err = r.collection.ReplaceOne(ctx, bson.M{"_id": secret.Id}, secret); err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return ErrSecretNotFound
+	}
+	return
+}
+
+func (r *mongoSecretRepository) Delete(ctx context.Context, name string) (err error) {
+	var (
+		result *mongo.DeleteResult
+	)
+	if result, err = r.collection.DeleteOne(ctx, bson.M{"name": name}); err != nil {
+		return
+	}
+	if result.DeletedCount == 0 {
+		return ErrSecretNotFound
+	}
+	return
+}
+
+type memorySecretRepository struct {
+	mu      sync.RWMutex
+	secrets map[string]*Secret // key is name
+}
+
+func NewMemorySecretRepository() SecretRepository {
+	return &memorySecretRepository{secrets: make(map[string]*Secret)}
+}
+
+func (r *memorySecretRepository) Insert(ctx context.Context, secret *Secret) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.secrets[secret.Name]; exist {
+		return ErrSecretExist
+	}
+	s := *secret
+	r.secrets[secret.Name] = &s
+	return nil
+}
+
+func (r *memorySecretRepository) Get(ctx context.Context, name string) (*Secret, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if s, exist := r.secrets[name]; exist {
+		secret := *s
+		return &secret, nil
+	}
+	return nil, ErrSecretNotFound
+}
+
+func (r *memorySecretRepository) List(ctx context.Context) ([]*Secret, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	secrets := make([]*Secret, 0, len(r.secrets))
+	for _, s := range r.secrets {
+		secret := *s
+		secrets = append(secrets, &secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+	return secrets, nil
+}
+
+func (r *memorySecretRepository) Update(ctx context.Context, secret *Secret) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, exist := r.secrets[secret.Name]; !exist || s.Id != secret.Id {
+		return ErrSecretNotFound
+	}
+	s := *secret
+	r.secrets[secret.Name] = &s
+	return nil
+}
+
+func (r *memorySecretRepository) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.secrets[name]; !exist {
+		return ErrSecretNotFound
+	}
+	delete(r.secrets, name)
+	return nil
+}
@@ -0,0 +1,672 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"iCloud/commons"
+	"iCloud/log"
+	"net/http"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	STACK_STATUS_RUNNING = "running" // all containers of stack are running
+	STACK_STATUS_PARTIAL = "partial" // some containers of stack are not running
+	STACK_STATUS_STOPPED = "stopped"
+	STACK_STATUS_UNKNOWN = "unknown" // host of stack is unreachable
+)
+
+var (
+	Stacks       = NewMemoryStackRepository()
+	stackNameExp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// subset of docker-compose file, other keys such as "version" and "networks" are ignored
+type StackSpec struct {
+	Services map[string]*StackService `json:"services"`
+}
+
+type StackService struct {
+	Image       string            `json:"image"`
+	Command     stackCommand      `json:"command"`
+	WorkingDir  string            `json:"working_dir"` // "/" by default
+	Ports       []string          `json:"ports"`       // "host_port:container_port"
+	Volumes     []string          `json:"volumes"`     // "dir" or "dir:dir", host dir is mounted to the same path in container
+	Environment stackEnv          `json:"environment"`
+	Labels      map[string]string `json:"labels"`
+	DependsOn   []string          `json:"depends_on"` // service is started after them and can reach them by their names
+	Restart     string            `json:"restart"`
+	Cpus        json.Number       `json:"cpus"`
+	MemLimit    string            `json:"mem_limit"` // e.g. "512m", "2g"
+}
+
+// command is string or list of arguments, arguments in list are kept as they are, so they can contain spaces
+type stackCommand struct {
+	Line string
+	Args []string
+}
+
+func (c *stackCommand) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Line); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &c.Args); err != nil {
+		return errors.New("command must be string or list of string")
+	}
+	return nil
+}
+
+// environment is map or list of "NAME=value"
+type stackEnv map[string]string
+
+func (e *stackEnv) UnmarshalJSON(data []byte) error {
+	var (
+		values map[string]interface{}
+		list   []string
+	)
+
+	*e = make(stackEnv)
+	if err := json.Unmarshal(data, &values); err == nil {
+		for k, v := range values {
+			if (*e)[k] = ""; v != nil {
+				(*e)[k] = fmt.Sprint(v)
+			}
+		}
+		return nil
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("environment must be map or list of \"NAME=value\"")
+	}
+	for _, item := range list {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return errors.New("value of env " + item + " is not set")
+		}
+		(*e)[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+type StackServiceState struct {
+	Name          string                  `json:"name" bson:"name"`
+	DependsOn     []string                `json:"dependsOn" bson:"dependsOn"`
+	ContainerId   string                  `json:"containerId" bson:"containerId"`
+	Configuration *ContainerConfiguration `json:"configuration" bson:"configuration"`
+	State         string                  `json:"state" bson:"-"` // state of container read from host, "missing" if it is removed
+}
+
+// containers deployed on one host from a spec, they are started in order of dependency and stopped in reverse order
+type Stack struct {
+	Id         string               `json:"id" bson:"_id"`
+	Name       string               `json:"name" bson:"name"` // name of container is "stack-service"
+	Project    string               `json:"project" bson:"project"`
+	Owner      string               `json:"owner" bson:"owner"`
+	HostIp     string               `json:"hostIp" bson:"hostIp"`
+	HostPort   string               `json:"hostPort" bson:"hostPort"` // docker remote api port
+	Spec       string               `json:"spec" bson:"spec"`
+	Services   []*StackServiceState `json:"services" bson:"services"` // in order of start
+	Status     string               `json:"status" bson:"-"`          // aggregated from state of containers
+	CreateTime int64                `json:"createTime" bson:"createTime"`
+}
+
+type StackRequest struct {
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	Spec    string `json:"spec"` // yaml or json
+}
+
+// deep copy, stack is changed by state of containers when it is returned
+func (s *Stack) copy() *Stack {
+	stack := new(Stack)
+	content, _ := json.Marshal(s)
+	_ = json.Unmarshal(content, stack)
+	return stack
+}
+
+func (s *Stack) allowed(user *User, permission string) bool {
+	return user.Allowed(s.Owner, s.Project, permission)
+}
+
+// json is yaml too, so both are parsed by yaml
+func stackSpecParse(content string) (spec *StackSpec, err error) {
+	var (
+		data []byte
+	)
+
+	if data, err = yaml.YAMLToJSON([]byte(content)); err != nil {
+		return nil, errors.New("spec is not yaml or json: " + err.Error())
+	}
+	spec = new(StackSpec)
+	if err = json.Unmarshal(data, spec); err != nil {
+		return nil, errors.New("spec error: " + err.Error())
+	}
+	if len(spec.Services) == 0 {
+		return nil, errors.New("no service in spec")
+	}
+	return
+}
+
+// names of services in order of dependency, services which do not depend on each other are in order of name
+func (spec *StackSpec) order() (names []string, err error) {
+	var (
+		started = make(map[string]bool)
+	)
+
+	for name, svc := range spec.Services {
+		if !stackNameExp.MatchString(name) {
+			return nil, errors.New("name of service " + name + " can only contain letters, digits, \"_\", \".\" and \"-\"")
+		}
+		if svc == nil {
+			return nil, errors.New("service " + name + " is empty")
+		}
+		for _, dep := range svc.DependsOn {
+			if _, exist := spec.Services[dep]; !exist || dep == name {
+				return nil, errors.New("service " + name + " depends on " + dep + " which is not other service in spec")
+			}
+		}
+	}
+
+	for len(names) < len(spec.Services) {
+		ready := make([]string, 0)
+		for name, svc := range spec.Services {
+			if started[name] {
+				continue
+			}
+			depsStarted := true
+			for _, dep := range svc.DependsOn {
+				depsStarted = depsStarted && started[dep]
+			}
+			if depsStarted {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			return nil, errors.New("circular dependency between services")
+		}
+
+		sort.Strings(ready)
+		for _, name := range ready {
+			started[name] = true
+		}
+		names = append(names, ready...)
+	}
+	return
+}
+
+// configuration of container of service, dependencies are linked by their service names
+func (svc *StackService) configuration(stack, project, name string) (conf *ContainerConfiguration, err error) {
+	var (
+		mem int64
+	)
+
+	if svc.Cpus == "" || svc.MemLimit == "" {
+		return nil, errors.New("cpus and mem_limit of service " + name + " are required")
+	}
+	if mem, err = units.RAMInBytes(svc.MemLimit); err != nil {
+		return nil, errors.New("mem_limit of service " + name + " error: " + err.Error())
+	}
+
+	conf = &ContainerConfiguration{
+		ContainerName: stack + "-" + name,
+		SourceDir:     make([]string, 0, len(svc.Volumes)),
+		ContainerPort: make([]string, 0, len(svc.Ports)),
+		HostPort:      make([]string, 0, len(svc.Ports)),
+		ImageName:     svc.Image,
+		MaxCpu:        svc.Cpus.String(),
+		MaxMem:        strconv.FormatFloat(float64(mem)/float64(commons.GB), 'f', -1, 64),
+		Commands:      make([]string, 0, 1),
+		Pwd:           svc.WorkingDir,
+		Gpus:          "0",
+		Project:       project,
+		Env:           svc.Environment,
+		Labels:        svc.Labels,
+		RestartPolicy: svc.Restart,
+		Links:         make([]string, 0, len(svc.DependsOn)),
+	}
+	if conf.Pwd == "" {
+		conf.Pwd = "/"
+	}
+	if svc.Command.Line != "" {
+		conf.Commands = append(conf.Commands, svc.Command.Line)
+	} else if len(svc.Command.Args) > 0 {
+		conf.Entrypoint = svc.Command.Args
+	}
+	// "on-failure:3" of compose
+	if strings.HasPrefix(svc.Restart, RESTART_POLICY_ON_FAILURE+":") {
+		conf.RestartPolicy = RESTART_POLICY_ON_FAILURE
+		if conf.RestartMaxRetry, err = strconv.Atoi(strings.TrimPrefix(svc.Restart, RESTART_POLICY_ON_FAILURE+":")); err != nil {
+			return nil, errors.New("max retries of restart of service " + name + " is not integer")
+		}
+	}
+
+	for _, port := range svc.Ports {
+		ports := strings.Split(strings.TrimSuffix(port, "/tcp"), ":")
+		if len(ports) != 2 {
+			return nil, errors.New("port " + port + " of service " + name + " must be \"host_port:container_port\"")
+		}
+		conf.HostPort, conf.ContainerPort = append(conf.HostPort, ports[0]), append(conf.ContainerPort, ports[1])
+	}
+
+	for _, volume := range svc.Volumes {
+		dirs := strings.Split(strings.TrimSuffix(volume, ":rw"), ":")
+		if dirs[0] == "" || len(dirs) > 2 || (len(dirs) == 2 && dirs[1] != dirs[0]) {
+			return nil, errors.New("volume " + volume + " of service " + name + " must mount host dir to the same path in container, e.g. \"/data:/data\"")
+		}
+		conf.SourceDir = append(conf.SourceDir, dirs[0])
+	}
+
+	for _, dep := range svc.DependsOn {
+		conf.Links = append(conf.Links, stack+"-"+dep+":"+dep)
+	}
+
+	if err = conf.confCheck(); err != nil {
+		return nil, errors.New("service " + name + " error: " + err.Error())
+	}
+	return
+}
+
+// host of stack is given by "ip" and "port" in url, or chosen by "strategy" in url to hold all services
+func stackHost(ctx *gin.Context, services []*StackServiceState) (ip, port string, err error) {
+	var (
+		cpu, mem float64
+		decision *PlacementDecision
+	)
+
+	if ip, port = ctx.Query("ip"), ctx.Query("port"); ip != "" || port != "" {
+		if ip == "" || port == "" {
+			return "", "", errors.New("param error, both ip and port of host are required")
+		}
+		if host, exist := HostRegistry.ByIp(ip); !exist || host.ApiPort != port {
+			return "", "", errors.New("host " + ip + ":" + port + " is not registered or it is offline")
+		}
+		return
+	}
+
+	for _, svc := range services {
+		serviceCpu, _ := strconv.ParseFloat(svc.Configuration.MaxCpu, 64)
+		serviceMem, _ := strconv.ParseFloat(svc.Configuration.MaxMem, 64)
+		cpu, mem = cpu+serviceCpu, mem+serviceMem
+	}
+	total := &ContainerConfiguration{MaxCpu: strconv.FormatFloat(cpu, 'f', -1, 64), MaxMem: strconv.FormatFloat(mem, 'f', -1, 64)}
+
+	hosts, _ := HostRegistry.List()
+	if decision, err = placeContainer(hosts, total, ctx.DefaultQuery("strategy", PLACEMENT_BIN_PACK)); err != nil {
+		return
+	}
+	return decision.Host.Ip, decision.Host.ApiPort, nil
+}
+
+// create containers of stack in order and start them, all of them are removed if any one fails
+func stackCreate(ctx *gin.Context, cli *client.Client, stack *Stack) (err error) {
+	host, hostExist := HostRegistry.ByIp(stack.HostIp)
+
+	for _, svc := range stack.Services {
+		if hostExist {
+			svc.Configuration.ClientIp, svc.Configuration.RpcPort = host.Ip, host.GrpcPort
+		}
+		// containers created before are counted in usage
+		var release func()
+		if release, err = containerQuotaCheck(ctx, svc.Configuration); err != nil {
+			break
+		}
+
+		deployment := newDeployment(ctx, DEPLOY_ACTION_CREATE, stack.HostIp, stack.HostPort)
+		deployment.Configuration = svc.Configuration
+		svc.ContainerId, err = createContainer(cli, svc.Configuration)
+		release()
+		deployment.ContainerId = svc.ContainerId
+		deployment.finish(err)
+		if err != nil {
+			err = errors.New("service " + svc.Name + " error: " + err.Error())
+			break
+		}
+	}
+
+	if err == nil {
+		err = stackAction(ctx, cli, stack, DEPLOY_ACTION_START)
+	}
+	if err != nil {
+		stackRollback(cli, stack)
+	}
+	return
+}
+
+// remove containers of stack which fails to deploy
+func stackRollback(cli *client.Client, stack *Stack) {
+	for _, svc := range stack.Services {
+		if svc.ContainerId == "" {
+			continue
+		}
+		if err := cli.ContainerRemove(context.TODO(), svc.ContainerId, types.ContainerRemoveOptions{Force: true}); err != nil {
+			log.Logger.Errorf("apps.stack.stackRollback() error, remove container[%s] of stack %s error: %v", svc.ContainerId, stack.Name, err)
+		}
+		svc.ContainerId = ""
+	}
+}
+
+// start services in order, or stop or remove them in reverse order, container which is removed already is skipped when removing
+func stackAction(ctx *gin.Context, cli *client.Client, stack *Stack, action string) (err error) {
+	services := make([]*StackServiceState, 0, len(stack.Services))
+	for i := range stack.Services {
+		if action == DEPLOY_ACTION_START {
+			services = append(services, stack.Services[i])
+		} else {
+			services = append(services, stack.Services[len(stack.Services)-1-i])
+		}
+	}
+
+	for _, svc := range services {
+		if svc.ContainerId == "" {
+			continue
+		}
+		if _, inspectErr := cli.ContainerInspect(ctx, svc.ContainerId); action == DEPLOY_ACTION_REMOVE && client.IsErrNotFound(inspectErr) {
+			continue
+		}
+
+		deployment := newDeployment(ctx, action, stack.HostIp, stack.HostPort)
+		deployment.ContainerId, deployment.ContainerName = svc.ContainerId, svc.Configuration.ContainerName
+		switch action {
+		case DEPLOY_ACTION_START:
+			err = startContainer(svc.ContainerId, cli)
+		case DEPLOY_ACTION_STOP:
+			err = stopContainer(svc.ContainerId, cli)
+		case DEPLOY_ACTION_REMOVE:
+			err = removeContainer(svc.ContainerId, cli)
+		}
+		deployment.finish(err)
+		if err != nil {
+			return errors.New("service " + svc.Name + " error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// read state of containers of stack from its host, status of stack is aggregated from them
+func stackStatus(ctx context.Context, stack *Stack) {
+	var (
+		cli        *client.Client
+		containers []types.Container
+		states     = make(map[string]string)
+		running    int
+		err        error
+	)
+
+	if cli, err = DockerClientPool.Get(stack.HostIp, stack.HostPort); err == nil {
+		containers, err = cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	}
+	if err != nil {
+		stack.Status = STACK_STATUS_UNKNOWN
+		return
+	}
+
+	for _, c := range containers {
+		states[c.ID] = c.State
+	}
+	for _, svc := range stack.Services {
+		if svc.State = states[svc.ContainerId]; svc.State == "" {
+			svc.State = "missing"
+		}
+		if svc.State == "running" {
+			running++
+		}
+	}
+
+	switch running {
+	case len(stack.Services):
+		stack.Status = STACK_STATUS_RUNNING
+	case 0:
+		stack.Status = STACK_STATUS_STOPPED
+	default:
+		stack.Status = STACK_STATUS_PARTIAL
+	}
+}
+
+// stack which can be accessed by caller with permission
+func stackOf(ctx *gin.Context, name, permission string) (stack *Stack, err error) {
+	if stack, err = Stacks.Get(ctx, name); err != nil {
+		if err != ErrStackNotFound {
+			log.Logger.Errorf("apps.stack.stackOf() error, get stack %s error: %v", name, err)
+		}
+		return nil, errors.New("get stack error")
+	}
+	if !stack.allowed(callerUser(ctx), permission) {
+		return nil, ErrPermissionDenied
+	}
+	return
+}
+
+// deploy stack from spec on host of "ip" and "port" in url, or on host chosen by "strategy" in url
+func StackDeploy(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		m     = "apps.stack.StackDeploy()"
+		req   = new(StackRequest)
+		spec  *StackSpec
+		order []string
+		stack *Stack
+		cli   *client.Client
+		err   error
+	)
+
+	if err = ctx.BindJSON(req); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+	if !stackNameExp.MatchString(req.Name) {
+		rsp["ErrorCode"], rsp["Data"] = 1, "name of stack can only contain letters, digits, \"_\", \".\" and \"-\""
+		goto RESPONSE
+	}
+	if spec, err = stackSpecParse(req.Spec); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	if order, err = spec.order(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	stack = &Stack{
+		Id:         primitive.NewObjectID().Hex(),
+		Name:       req.Name,
+		Project:    req.Project,
+		Owner:      callerUser(ctx).Username,
+		Spec:       req.Spec,
+		Services:   make([]*StackServiceState, 0, len(order)),
+		CreateTime: time.Now().Unix(),
+	}
+	for _, name := range order {
+		svc := &StackServiceState{Name: name, DependsOn: spec.Services[name].DependsOn}
+		if svc.Configuration, err = spec.Services[name].configuration(req.Name, req.Project, name); err != nil {
+			rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+			goto RESPONSE
+		}
+		if err = containerOwnerSet(ctx, svc.Configuration); err != nil {
+			rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+			goto RESPONSE
+		}
+		stack.Services = append(stack.Services, svc)
+	}
+
+	if stack.HostIp, stack.HostPort, err = stackHost(ctx, stack.Services); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	if cli, err = DockerClientPool.Get(stack.HostIp, stack.HostPort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	// stack is saved before its containers are created, so stacks of the same name can not be deployed at the same time
+	if err = Stacks.Insert(ctx, stack); err != nil {
+		if err != ErrStackExist {
+			log.Logger.Errorf("%s error, insert stack %s error: %v", m, stack.Name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "create stack error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = stackCreate(ctx, cli, stack); err != nil {
+		stackDelete(stack)
+		rsp["ErrorCode"], rsp["Data"] = 1, "deploy stack error, containers of stack are removed: "+err.Error()
+		goto RESPONSE
+	}
+	if err = Stacks.Update(ctx, stack); err != nil {
+		log.Logger.Errorf("%s error, save containers of stack %s error: %v", m, stack.Name, err)
+		stackRollback(cli, stack)
+		stackDelete(stack)
+		rsp["ErrorCode"], rsp["Data"] = 1, "save stack error, containers of stack are removed"
+		goto RESPONSE
+	}
+
+	stackStatus(ctx, stack)
+	log.Logger.Infof("stack %s with %d services is deployed on %s by %s", stack.Name, len(stack.Services), stack.HostIp, stack.Owner)
+	rsp["ErrorCode"], rsp["Data"] = 0, stack
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// stack which fails to deploy is deleted, so it can be deployed again
+func stackDelete(stack *Stack) {
+	mongoCtx, mongoCancel := context.WithTimeout(context.TODO(), commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	if err := Stacks.Delete(mongoCtx, stack.Name); err != nil {
+		log.Logger.Errorf("apps.stack.stackDelete() error, delete stack %s which fails to deploy error: %v", stack.Name, err)
+	}
+}
+
+// stacks which can be read by caller, with status aggregated from their containers
+func StackList(ctx *gin.Context) {
+	var (
+		rsp     = make(gin.H)
+		m       = "apps.stack.StackList()"
+		user    = callerUser(ctx)
+		stacks  []*Stack
+		visible = make([]*Stack, 0)
+		err     error
+	)
+
+	if stacks, err = Stacks.List(ctx); err != nil {
+		log.Logger.Errorf("%s error, list stacks error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list stacks error"
+		goto RESPONSE
+	}
+
+	for _, s := range stacks {
+		if s.allowed(user, PERMISSION_READ) {
+			stackStatus(ctx, s)
+			visible = append(visible, s)
+		}
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, visible
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func StackDetail(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		stack *Stack
+		err   error
+	)
+
+	if stack, err = stackOf(ctx, ctx.Param("name"), PERMISSION_READ); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	stackStatus(ctx, stack)
+	rsp["ErrorCode"], rsp["Data"] = 0, stack
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// start or stop all containers of stack
+func stackActionHandle(ctx *gin.Context, action string) {
+	var (
+		rsp   = make(gin.H)
+		name  = ctx.Param("name")
+		stack *Stack
+		cli   *client.Client
+		err   error
+	)
+
+	if stack, err = stackOf(ctx, name, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	if cli, err = DockerClientPool.Get(stack.HostIp, stack.HostPort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = stackAction(ctx, cli, stack, action); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, action+" stack error: "+err.Error()
+		goto RESPONSE
+	}
+
+	stackStatus(ctx, stack)
+	log.Logger.Infof("%s of stack %s is done by %s", action, name, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, stack
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// start containers of stack in order of dependency
+func StackStart(ctx *gin.Context) {
+	stackActionHandle(ctx, DEPLOY_ACTION_START)
+}
+
+// stop containers of stack in reverse order of dependency
+func StackStop(ctx *gin.Context) {
+	stackActionHandle(ctx, DEPLOY_ACTION_STOP)
+}
+
+// stop and remove containers of stack in reverse order of dependency, stack is deleted after all of them are removed
+func StackRemove(ctx *gin.Context) {
+	var (
+		rsp   = make(gin.H)
+		m     = "apps.stack.StackRemove()"
+		name  = ctx.Param("name")
+		stack *Stack
+		cli   *client.Client
+		err   error
+	)
+
+	if stack, err = stackOf(ctx, name, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	if cli, err = DockerClientPool.Get(stack.HostIp, stack.HostPort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	if err = stackAction(ctx, cli, stack, DEPLOY_ACTION_REMOVE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "remove stack error: "+err.Error()
+		goto RESPONSE
+	}
+	if err = Stacks.Delete(ctx, name); err != nil {
+		log.Logger.Errorf("%s error, delete stack %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "delete stack error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("stack %s is removed by %s", name, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, struct{}{}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,177 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"iCloud/log"
+	"sort"
+	"sync"
+)
+
+const MONGO_COLLECTION_STACK = "stacks"
+
+var (
+	ErrStackNotFound = errors.New("stack dose not exist")
+	ErrStackExist    = errors.New("stack exists already")
+)
+
+// storage of stacks, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type StackRepository interface {
+	Insert(ctx context.Context, stack *Stack) error
+	Get(ctx context.Context, name string) (*Stack, error)
+	List(ctx context.Context) ([]*Stack, error)
+	Update(ctx context.Context, stack *Stack) error
+	Delete(ctx context.Context, name string) error
+}
+
+type mongoStackRepository struct {
+	collection *mongo.Collection
+}
+
+// name of stack is unique index, so stacks of the same name deployed at the same time can not be both inserted
+func NewMongoStackRepository(m *commons.MONGO) StackRepository {
+	collection := m.Collection(MONGO_COLLECTION_STACK)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), commons.MONGO_TIMEOUT)
+	defer cancel()
+	if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		log.Logger.Errorf("apps.stackRepository.NewMongoStackRepository() error, create unique index of name error: %v", err)
+	}
+	return &mongoStackRepository{collection: collection}
+}
+
+func (r *mongoStackRepository) Insert(ctx context.Context, stack *Stack) (err error) {
+	if _, err = r.collection.InsertOne(ctx, stack); mongoDuplicateKey(err) {
+		return ErrStackExist
+	}
+	return
+}
+
+func mongoDuplicateKey(err error) bool {
+	if e, ok := err.(mongo.WriteException); ok {
+		for _, we := range e.WriteErrors {
+			if we.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *mongoStackRepository) Get(ctx context.Context, name string) (stack *Stack, err error) {
+	stack = new(Stack)
+	if err = r.collection.FindOne(ctx, bson.M{"name": name}).Decode(stack); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrStackNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoStackRepository) List(ctx context.Context) (stacks []*Stack, err error) {
+	var (
+		cursor *mongo.Cursor
+	)
+
+	if cursor, err = r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}})); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	stacks = make([]*Stack, 0)
+	err = cursor.All(ctx, &stacks)
+	return
+}
+
+func (r *mongoStackRepository) Update(ctx context.Context, stack *Stack) (err error) {
+	var (
+		result *mongo.UpdateResult
+	)
+	if result, err = r.collection.ReplaceOne(ctx, bson.M{"_id": stack.Id}, stack); err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return ErrStackNotFound
+	}
+	return
+}
+
+func (r *mongoStackRepository) Delete(ctx context.Context, name string) (err error) {
+	var (
+		result *mongo.DeleteResult
+	)
+	if result, err = r.collection.DeleteOne(ctx, bson.M{"name": name}); err != nil {
+		return
+	}
+	if result.DeletedCount == 0 {
+		return ErrStackNotFound
+	}
+	return
+}
+
+type memoryStackRepository struct {
+	mu     sync.RWMutex
+	stacks map[string]*Stack // key is name
+}
+
+func NewMemoryStackRepository() StackRepository {
+	return &memoryStackRepository{stacks: make(map[string]*Stack)}
+}
+
+func (r *memoryStackRepository) Insert(ctx context.Context, stack *Stack) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.stacks[stack.Name]; exist {
+		return ErrStackExist
+	}
+	r.stacks[stack.Name] = stack.copy()
+	return nil
+}
+
+func (r *memoryStackRepository) Get(ctx context.Context, name string) (*Stack, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if s, exist := r.stacks[name]; exist {
+		return s.copy(), nil
+	}
+	return nil, ErrStackNotFound
+}
+
+func (r *memoryStackRepository) List(ctx context.Context) ([]*Stack, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stacks := make([]*Stack, 0, len(r.stacks))
+	for _, s := range r.stacks {
+		stacks = append(stacks, s.copy())
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Name < stacks[j].Name })
+	return stacks, nil
+}
+
+func (r *memoryStackRepository) Update(ctx context.Context, stack *Stack) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, exist := r.stacks[stack.Name]; !exist || s.Id != stack.Id {
+		return ErrStackNotFound
+	}
+	r.stacks[stack.Name] = stack.copy()
+	return nil
+}
+
+func (r *memoryStackRepository) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.stacks[name]; !exist {
+		return ErrStackNotFound
+	}
+	delete(r.stacks, name)
+	return nil
+}
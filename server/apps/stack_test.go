@@ -0,0 +1,87 @@
+package apps
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStackSpecOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		services map[string][]string // service and its dependencies
+		want     []string
+		err      string
+	}{
+		{"no dependency in order of name", map[string][]string{"web": nil, "db": nil, "cache": nil}, []string{"cache", "db", "web"}, ""},
+		{"chain", map[string][]string{"web": {"api"}, "api": {"db"}, "db": nil}, []string{"db", "api", "web"}, ""},
+		{"diamond", map[string][]string{"web": {"api", "worker"}, "api": {"db"}, "worker": {"db"}, "db": nil}, []string{"db", "api", "worker", "web"}, ""},
+		{"levels", map[string][]string{"web": {"db"}, "db": nil, "cache": nil, "proxy": {"web", "cache"}}, []string{"cache", "db", "web", "proxy"}, ""},
+		{"missing dependency", map[string][]string{"web": {"db"}}, nil, "depends on db which is not other service"},
+		{"self dependency", map[string][]string{"web": {"web"}}, nil, "depends on web which is not other service"},
+		{"cycle", map[string][]string{"a": {"b"}, "b": {"a"}}, nil, "circular dependency"},
+		{"cycle after ready services", map[string][]string{"db": nil, "a": {"db", "c"}, "b": {"a"}, "c": {"b"}}, nil, "circular dependency"},
+		{"invalid name", map[string][]string{"web/api": nil}, nil, "can only contain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &StackSpec{Services: make(map[string]*StackService)}
+			for name, deps := range tt.services {
+				spec.Services[name] = &StackService{DependsOn: deps}
+			}
+
+			got, err := spec.order()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("order() error = %v, want error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("order() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackSpecParseCommand(t *testing.T) {
+	spec, err := stackSpecParse(`
+services:
+  web:
+    image: nginx
+    command: nginx -g daemon-off
+    cpus: 0.5
+    mem_limit: 512m
+  job:
+    image: busybox
+    command: ["sh", "-c", "echo hello world"]
+    cpus: 1
+    mem_limit: 1g
+`)
+	if err != nil {
+		t.Fatalf("stackSpecParse() error: %v", err)
+	}
+
+	web, err := spec.Services["web"].configuration("s", "", "web")
+	if err != nil {
+		t.Fatalf("configuration() error: %v", err)
+	}
+	if !reflect.DeepEqual(web.Commands, []string{"nginx -g daemon-off"}) || len(web.Entrypoint) != 0 {
+		t.Errorf("command of web = %v %v, want one command", web.Commands, web.Entrypoint)
+	}
+
+	job, err := spec.Services["job"].configuration("s", "", "job")
+	if err != nil {
+		t.Fatalf("configuration() error: %v", err)
+	}
+	if !reflect.DeepEqual(job.Entrypoint, []string{"sh", "-c", "echo hello world"}) || len(job.Commands) != 0 {
+		t.Errorf("command of job = %v %v, want args kept", job.Commands, job.Entrypoint)
+	}
+	if job.MaxCpu != "1" || job.MaxMem != "1" {
+		t.Errorf("resources of job = %s cpu %s GB, want 1 and 1", job.MaxCpu, job.MaxMem)
+	}
+}
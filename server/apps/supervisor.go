@@ -0,0 +1,331 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"iCloud/commons"
+	"iCloud/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ContainerSupervisor = newContainerSupervisor()
+	MovedContainers     = NewMemoryMovedContainerRepository()
+)
+
+// container which is rescheduled when its host is offline
+type SupervisedContainer struct {
+	Id       string `json:"id" bson:"_id"`
+	Name     string `json:"name" bson:"name"`
+	State    string `json:"state" bson:"state"`
+	HostIp   string `json:"hostIp" bson:"hostIp"`
+	HostPort string `json:"hostPort" bson:"hostPort"`
+	MovedTo  string `json:"movedTo,omitempty" bson:"movedTo"` // host which container is created on when its host is offline
+	MovedId  string `json:"movedId,omitempty" bson:"movedId"` // id of container created on other host
+}
+
+// containers of offline host can not be listed, so containers with reschedule of online hosts
+// are listed every SUPERVISOR_SNAPSHOT_INTERVAL, and the last list is used when host is offline.
+// containers moved from offline host are kept in MovedContainers, they are removed when host is online again
+type containerSupervisor struct {
+	mu        sync.Mutex
+	snapshots map[string][]*SupervisedContainer // key is ip of host
+	events    []*HostEvent                      // events handled by worker in order
+	wake      chan struct{}
+}
+
+func newContainerSupervisor() *containerSupervisor {
+	return &containerSupervisor{
+		snapshots: make(map[string][]*SupervisedContainer),
+		events:    make([]*HostEvent, 0),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+func (s *containerSupervisor) snapshot(ctx context.Context) {
+	var (
+		m          = "apps.supervisor.snapshot()"
+		hosts      []*commons.Host
+		containers []types.Container
+		args       = filters.NewArgs(filters.Arg("label", LABEL_RESCHEDULE+"=true"))
+	)
+
+	hosts, _ = HostRegistry.List()
+	for _, host := range hosts {
+		cli, err := DockerClientPool.Get(host.Ip, host.ApiPort)
+		if err != nil {
+			continue
+		}
+		listCtx, listCancel := context.WithTimeout(ctx, commons.DOCKER_PING_TIMEOUT)
+		containers, err = cli.ContainerList(listCtx, types.ContainerListOptions{All: true, Filters: args})
+		listCancel()
+		if err != nil {
+			log.Logger.Errorf("%s error, list containers of %s error, last list is kept: %v", m, host.Ip, err)
+			continue
+		}
+
+		supervised := make([]*SupervisedContainer, 0, len(containers))
+		for _, c := range containers {
+			name := c.ID
+			if len(c.Names) > 0 {
+				name = c.Names[0][1:]
+			}
+			supervised = append(supervised, &SupervisedContainer{Id: c.ID, Name: name, State: c.State, HostIp: host.Ip, HostPort: host.ApiPort})
+		}
+
+		s.mu.Lock()
+		s.snapshots[host.Ip] = supervised
+		s.mu.Unlock()
+	}
+}
+
+// create running containers of offline host on other hosts
+func (s *containerSupervisor) hostOffline(ctx context.Context, ip string) {
+	var (
+		m = "apps.supervisor.hostOffline()"
+	)
+
+	s.mu.Lock()
+	containers := s.snapshots[ip]
+	delete(s.snapshots, ip)
+	s.mu.Unlock()
+
+	for _, c := range containers {
+		if c.State != "running" && c.State != "restarting" {
+			continue
+		}
+		if err := s.reschedule(ctx, c); err != nil {
+			log.Logger.Errorf("%s error, reschedule container %s[%s] of offline host %s error: %v", m, c.Name, c.Id, ip, err)
+			continue
+		}
+
+		mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+		err := MovedContainers.Insert(mongoCtx, c)
+		mongoCancel()
+		if err != nil {
+			log.Logger.Errorf("%s error, save container %s[%s] moved from %s error, it is not removed when host is online: %v", m, c.Name, c.Id, ip, err)
+		}
+	}
+}
+
+// containers moved to other hosts are removed, or there are two same containers after they are restarted by docker
+func (s *containerSupervisor) hostOnline(ctx context.Context, ip string) {
+	var (
+		m          = "apps.supervisor.hostOnline()"
+		containers []*SupervisedContainer
+		cli        *client.Client
+		err        error
+	)
+
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	if containers, err = MovedContainers.Find(mongoCtx, ip); err != nil {
+		log.Logger.Errorf("%s error, find containers moved from %s error: %v", m, ip, err)
+		return
+	}
+
+	for _, c := range containers {
+		if cli, err = DockerClientPool.Get(c.HostIp, c.HostPort); err != nil {
+			log.Logger.Errorf("%s error, connect to docker api of %s error, container %s[%s] moved is not removed: %v", m, ip, c.Name, c.Id, err)
+			continue
+		}
+		if err = removeContainer(c.Id, cli); err != nil {
+			log.Logger.Errorf("%s error, remove container %s[%s] moved from %s error: %v", m, c.Name, c.Id, ip, err)
+			continue
+		}
+		if err = MovedContainers.Delete(mongoCtx, c.Id); err != nil {
+			log.Logger.Errorf("%s error, delete record of container %s[%s] moved from %s error: %v", m, c.Name, c.Id, ip, err)
+		}
+		log.Logger.Infof("container %s[%s] is removed from %s, it is moved to other host when host is offline", c.Name, c.Id, ip)
+	}
+}
+
+// create and start container on host chosen by scheduler, with configuration of its last create deployment.
+// owner of container must still be allowed to create it, e.g. role, project, secrets and quota of owner are checked again
+func (s *containerSupervisor) reschedule(ctx context.Context, c *SupervisedContainer) (err error) {
+	var (
+		m        = "apps.supervisor.reschedule()"
+		origin   *Deployment
+		owner    *User
+		hosts    []*commons.Host
+		decision *PlacementDecision
+		cli      *client.Client
+		conf     ContainerConfiguration
+		release  func()
+	)
+
+	mongoCtx, mongoCancel := context.WithTimeout(ctx, commons.MONGO_TIMEOUT)
+	defer mongoCancel()
+	if origin, err = deploymentConfigurationOf(mongoCtx, c.Id); err != nil {
+		if err == ErrDeploymentNotFound {
+			err = errors.New("configuration of container is not found in deployments")
+		}
+		return
+	}
+
+	conf = *origin.Configuration
+	if owner, err = Users.Get(mongoCtx, conf.Owner); err != nil {
+		return errors.New("get owner " + conf.Owner + " of container error: " + err.Error())
+	}
+	if err = userOwnerSet(mongoCtx, owner, &conf); err != nil {
+		return
+	}
+	if release, err = userQuotaCheck(ctx, owner, &conf); err != nil {
+		return
+	}
+	defer release()
+
+	hosts, _ = HostRegistry.List()
+	if decision, err = placeContainer(hosts, &conf, PLACEMENT_SPREAD); err != nil {
+		return
+	}
+	conf.ClientIp, conf.RpcPort = decision.Host.Ip, decision.Host.GrpcPort
+
+	if cli, err = DockerClientPool.Get(decision.Host.Ip, decision.Host.ApiPort); err != nil {
+		return
+	}
+
+	deployment := &Deployment{
+		Id:            primitive.NewObjectID().Hex(),
+		Action:        DEPLOY_ACTION_RESCHEDULE,
+		HostIp:        decision.Host.Ip,
+		HostPort:      decision.Host.ApiPort,
+		Configuration: &conf,
+		Caller:        origin.Caller,
+		CreateTime:    time.Now().Unix(),
+	}
+	if deployment.ContainerId, err = createContainer(cli, &conf); err == nil {
+		// container which fails to start is removed, so it can be created again with the same name
+		if err = startContainer(deployment.ContainerId, cli); err != nil {
+			if rmErr := cli.ContainerRemove(context.TODO(), deployment.ContainerId, types.ContainerRemoveOptions{Force: true}); rmErr != nil {
+				log.Logger.Errorf("%s error, remove container[%s] which fails to start error: %v", m, deployment.ContainerId, rmErr)
+			}
+		}
+	}
+	deployment.finish(err)
+	if err != nil {
+		return
+	}
+
+	c.MovedTo, c.MovedId = decision.Host.Ip, deployment.ContainerId
+	log.Logger.Infof("container %s[%s] of offline host %s is rescheduled to %s[%s]", c.Name, c.Id, c.HostIp, decision.Host.Ip, deployment.ContainerId)
+	return nil
+}
+
+// host events are queued and handled by worker, so pulling images when containers are rescheduled does not block watching
+func (s *containerSupervisor) eventPush(event *HostEvent) {
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *containerSupervisor) eventPop() *HostEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.events) == 0 {
+		return nil
+	}
+	event := s.events[0]
+	s.events = s.events[1:]
+	return event
+}
+
+func (s *containerSupervisor) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+
+		for event := s.eventPop(); event != nil; event = s.eventPop() {
+			switch event.Type {
+			case HOST_EVENT_OFFLINE:
+				s.hostOffline(ctx, event.Ip)
+			case HOST_EVENT_ONLINE:
+				s.hostOnline(ctx, event.Ip)
+			}
+		}
+	}
+}
+
+// keep snapshots of containers with reschedule, and reschedule them when their host is offline
+func (s *containerSupervisor) Watch(ctx context.Context) {
+	var (
+		ticker              = time.NewTicker(commons.SUPERVISOR_SNAPSHOT_INTERVAL)
+		events, unsubscribe = HostEventSubscribe()
+	)
+	defer ticker.Stop()
+	defer unsubscribe()
+
+	go s.worker(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.snapshot(ctx)
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Type == HOST_EVENT_OFFLINE || event.Type == HOST_EVENT_ONLINE {
+				s.eventPush(event)
+			}
+		}
+	}
+}
+
+func (s *containerSupervisor) status(ctx context.Context) (gin.H, error) {
+	snapshots, moved := make(map[string][]SupervisedContainer), make(map[string][]SupervisedContainer)
+
+	s.mu.Lock()
+	for ip, containers := range s.snapshots {
+		for _, c := range containers {
+			snapshots[ip] = append(snapshots[ip], *c)
+		}
+	}
+	s.mu.Unlock()
+
+	containers, err := MovedContainers.Find(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		moved[c.HostIp] = append(moved[c.HostIp], *c)
+	}
+	return gin.H{"supervised": snapshots, "moved": moved}, nil
+}
+
+// containers watched by supervisor and containers moved from offline hosts
+func ContainerSupervisorStatus(ctx *gin.Context) {
+	var (
+		rsp    = make(gin.H)
+		m      = "apps.supervisor.ContainerSupervisorStatus()"
+		status gin.H
+		err    error
+	)
+
+	if status, err = ContainerSupervisor.status(ctx); err != nil {
+		log.Logger.Errorf("%s error, find moved containers error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "find moved containers error"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, status
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
@@ -0,0 +1,355 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"iCloud/log"
+	"net/http"
+	"time"
+)
+
+var Templates = NewMemoryTemplateRepository()
+
+// named preset of container configuration, template without project is shared by all users
+type ContainerTemplate struct {
+	Id            string                  `json:"id" bson:"_id"`
+	Name          string                  `json:"name" bson:"name"`
+	Description   string                  `json:"description" bson:"description"`
+	Project       string                  `json:"project" bson:"project"`
+	Owner         string                  `json:"owner" bson:"owner"`
+	Configuration *ContainerConfiguration `json:"configuration" bson:"configuration"`
+	CreateTime    int64                   `json:"createTime" bson:"createTime"`
+	UpdateTime    int64                   `json:"updateTime" bson:"updateTime"`
+}
+
+type ContainerTemplateRequest struct {
+	Name          string                  `json:"name"`
+	Description   string                  `json:"description"`
+	Project       string                  `json:"project"`
+	Configuration *ContainerConfiguration `json:"configuration"`
+}
+
+// deep copy, configuration of template is changed by overrides of request
+func (t *ContainerTemplate) copy() *ContainerTemplate {
+	template := *t
+	if t.Configuration != nil {
+		template.Configuration = new(ContainerConfiguration)
+		content, _ := json.Marshal(t.Configuration)
+		_ = json.Unmarshal(content, template.Configuration)
+	}
+	return &template
+}
+
+func (t *ContainerTemplate) allowed(user *User, permission string) bool {
+	if t.Project == "" && permission == PERMISSION_READ {
+		return true
+	}
+	return user.Allowed(t.Owner, t.Project, permission)
+}
+
+// configuration of template must pass confCheck, only name of container can be given when it is used
+func (req *ContainerTemplateRequest) check(user *User) (err error) {
+	var (
+		conf ContainerConfiguration
+	)
+
+	if req.Name == "" {
+		return errors.New("name of template is null")
+	}
+	if req.Configuration == nil {
+		return errors.New("configuration of template is null")
+	}
+	if req.Project != "" && !user.IsAdmin() && !user.InProject(req.Project) {
+		return errors.New("permission denied, caller is not member of project " + req.Project)
+	}
+
+	// fields of one container are not kept in template
+	req.Configuration.ClientIp, req.Configuration.RpcPort, req.Configuration.Owner, req.Configuration.RegistryAuth = "", "", "", nil
+
+	conf = *req.Configuration
+	if conf.ContainerName == "" {
+		conf.ContainerName = req.Name
+	}
+	return conf.confCheck()
+}
+
+// configuration of template which can be read by caller
+func templateConfiguration(ctx *gin.Context, name string) (*ContainerConfiguration, error) {
+	return userTemplateConfiguration(ctx, callerUser(ctx), name)
+}
+
+// configuration of template which can be read by user
+func userTemplateConfiguration(ctx context.Context, user *User, name string) (conf *ContainerConfiguration, err error) {
+	var (
+		m        = "apps.template.userTemplateConfiguration()"
+		template *ContainerTemplate
+	)
+
+	if template, err = Templates.Get(ctx, name); err != nil {
+		if err != ErrTemplateNotFound {
+			log.Logger.Errorf("%s error, get template %s error: %v", m, name, err)
+			err = errors.New("get template error")
+		}
+		return
+	}
+	if !template.allowed(user, PERMISSION_READ) {
+		return nil, ErrPermissionDenied
+	}
+
+	conf = template.copy().Configuration
+	if conf.Project == "" {
+		conf.Project = template.Project
+	}
+	return conf, nil
+}
+
+func TemplateCreate(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.template.TemplateCreate()"
+		user     = callerUser(ctx)
+		req      = new(ContainerTemplateRequest)
+		template *ContainerTemplate
+		err      error
+	)
+
+	if err = ctx.BindJSON(req); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+	if err = req.check(user); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	template = &ContainerTemplate{
+		Id:            primitive.NewObjectID().Hex(),
+		Name:          req.Name,
+		Description:   req.Description,
+		Project:       req.Project,
+		Owner:         user.Username,
+		Configuration: req.Configuration,
+		CreateTime:    time.Now().Unix(),
+		UpdateTime:    time.Now().Unix(),
+	}
+	if err = Templates.Insert(ctx, template); err != nil {
+		if err != ErrTemplateExist {
+			log.Logger.Errorf("%s error, insert template %s error: %v", m, req.Name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "create template error: "+err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("template %s is created by %s", template.Name, user.Username)
+	rsp["ErrorCode"], rsp["Data"] = 0, template
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// templates which can be read by caller
+func TemplateList(ctx *gin.Context) {
+	var (
+		rsp       = make(gin.H)
+		m         = "apps.template.TemplateList()"
+		user      = callerUser(ctx)
+		templates []*ContainerTemplate
+		visible   = make([]*ContainerTemplate, 0)
+		err       error
+	)
+
+	if templates, err = Templates.List(ctx); err != nil {
+		log.Logger.Errorf("%s error, list templates error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "list templates error"
+		goto RESPONSE
+	}
+
+	for _, t := range templates {
+		if t.allowed(user, PERMISSION_READ) {
+			visible = append(visible, t)
+		}
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, visible
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func TemplateDetail(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.template.TemplateDetail()"
+		name     = ctx.Param("name")
+		template *ContainerTemplate
+		err      error
+	)
+
+	if template, err = Templates.Get(ctx, name); err != nil {
+		if err != ErrTemplateNotFound {
+			log.Logger.Errorf("%s error, get template %s error: %v", m, name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get template error"
+		goto RESPONSE
+	}
+	if !template.allowed(callerUser(ctx), PERMISSION_READ) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, template
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// replace description, project and configuration of template, name can not be changed
+func TemplateUpdate(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.template.TemplateUpdate()"
+		name     = ctx.Param("name")
+		user     = callerUser(ctx)
+		req      = new(ContainerTemplateRequest)
+		template *ContainerTemplate
+		err      error
+	)
+
+	if template, err = Templates.Get(ctx, name); err != nil {
+		if err != ErrTemplateNotFound {
+			log.Logger.Errorf("%s error, get template %s error: %v", m, name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get template error"
+		goto RESPONSE
+	}
+	if !template.allowed(user, PERMISSION_WRITE) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	if err = ctx.BindJSON(req); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+	req.Name = name
+	if err = req.check(user); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	template.Description, template.Project, template.Configuration = req.Description, req.Project, req.Configuration
+	template.UpdateTime = time.Now().Unix()
+	if err = Templates.Update(ctx, template); err != nil {
+		log.Logger.Errorf("%s error, update template %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "update template error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("template %s is updated by %s", name, user.Username)
+	rsp["ErrorCode"], rsp["Data"] = 0, template
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+func TemplateDelete(ctx *gin.Context) {
+	var (
+		rsp      = make(gin.H)
+		m        = "apps.template.TemplateDelete()"
+		name     = ctx.Param("name")
+		template *ContainerTemplate
+		err      error
+	)
+
+	if template, err = Templates.Get(ctx, name); err != nil {
+		if err != ErrTemplateNotFound {
+			log.Logger.Errorf("%s error, get template %s error: %v", m, name, err)
+		}
+		rsp["ErrorCode"], rsp["Data"] = 1, "get template error"
+		goto RESPONSE
+	}
+	if !template.allowed(callerUser(ctx), PERMISSION_WRITE) {
+		rsp["ErrorCode"], rsp["Data"] = 1, ErrPermissionDenied.Error()
+		goto RESPONSE
+	}
+
+	if err = Templates.Delete(ctx, name); err != nil {
+		log.Logger.Errorf("%s error, delete template %s error: %v", m, name, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "delete template error"
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("template %s is deleted by %s", name, requestCaller(ctx))
+	rsp["ErrorCode"], rsp["Data"] = 0, struct{}{}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// create container on host of "ip" and "port" in url with configuration of template,
+// fields in request body override the configuration of template, "container_name" is required
+func ContainerCreateFromTemplate(ctx *gin.Context) {
+	var (
+		rsp           = make(gin.H)
+		m             = "apps.template.ContainerCreateFromTemplate()"
+		name          = ctx.Param("name")
+		hostIp        = ctx.Param("ip")
+		remotePort    = ctx.Param("port")
+		containerConf *ContainerConfiguration
+		cli           *client.Client
+		deployment    *Deployment
+		err           error
+		release       func()
+	)
+
+	if containerConf, err = templateConfiguration(ctx, name); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerConfOverride(ctx, containerConf); err != nil {
+		log.Logger.Errorf("%s error, read request data error: %v", m, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "request data error"
+		goto RESPONSE
+	}
+
+	if err = containerConf.confCheck(); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if err = containerOwnerSet(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	if release, err = containerQuotaCheck(ctx, containerConf); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	defer release()
+
+	if host, ok := HostRegistry.ByIp(hostIp); ok {
+		containerConf.ClientIp, containerConf.RpcPort = host.Ip, host.GrpcPort
+	}
+
+	if cli, err = DockerClientPool.Get(hostIp, remotePort); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, "connect to remote docker api error: "+err.Error()
+		goto RESPONSE
+	}
+
+	deployment = newDeployment(ctx, DEPLOY_ACTION_CREATE, hostIp, remotePort)
+	deployment.Configuration = containerConf
+	deployment.ContainerId, err = createContainer(cli, containerConf)
+	deployment.finish(err)
+	if err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	log.Logger.Infof("container %s[%s] is created from template %s by %s", containerConf.ContainerName, deployment.ContainerId, name, deployment.Caller)
+	rsp["ErrorCode"], rsp["Data"] = 0, deployment
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
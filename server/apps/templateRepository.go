@@ -0,0 +1,157 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"sort"
+	"sync"
+)
+
+const MONGO_COLLECTION_TEMPLATE = "templates"
+
+var (
+	ErrTemplateNotFound = errors.New("template dose not exist")
+	ErrTemplateExist    = errors.New("template exists already")
+)
+
+// storage of container templates, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type TemplateRepository interface {
+	Insert(ctx context.Context, template *ContainerTemplate) error
+	Get(ctx context.Context, name string) (*ContainerTemplate, error)
+	List(ctx context.Context) ([]*ContainerTemplate, error)
+	Update(ctx context.Context, template *ContainerTemplate) error
+	Delete(ctx context.Context, name string) error
+}
+
+type mongoTemplateRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoTemplateRepository(m *commons.MONGO) TemplateRepository {
+	return &mongoTemplateRepository{collection: m.Collection(MONGO_COLLECTION_TEMPLATE)}
+}
+
+func (r *mongoTemplateRepository) Insert(ctx context.Context, template *ContainerTemplate) (err error) {
+	if _, err = r.Get(ctx, template.Name); err == nil {
+		return ErrTemplateExist
+	} else if err != ErrTemplateNotFound {
+		return
+	}
+	_, err = r.collection.InsertOne(ctx, template)
+	return
+}
+
+func (r *mongoTemplateRepository) Get(ctx context.Context, name string) (template *ContainerTemplate, err error) {
+	template = new(ContainerTemplate)
+	if err = r.collection.FindOne(ctx, bson.M{"name": name}).Decode(template); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrTemplateNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoTemplateRepository) List(ctx context.Context) (templates []*ContainerTemplate, err error) {
+	var (
+		cursor *mongo.Cursor
+	)
+
+	if cursor, err = r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}})); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	templates = make([]*ContainerTemplate, 0)
+	err = cursor.All(ctx, &templates)
+	return
+}
+
+func (r *mongoTemplateRepository) Update(ctx context.Context, template *ContainerTemplate) (err error) {
+	var (
+		result *mongo.UpdateResult
+	)
+	if result, err = r.collection.ReplaceOne(ctx, bson.M{"_id": template.Id}, template); err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return ErrTemplateNotFound
+	}
+	return
+}
+
+func (r *mongoTemplateRepository) Delete(ctx context.Context, name string) (err error) {
+	var (
+		result *mongo.DeleteResult
+	)
+	if result, err = r.collection.DeleteOne(ctx, bson.M{"name": name}); err != nil {
+		return
+	}
+	if result.DeletedCount == 0 {
+		return ErrTemplateNotFound
+	}
+	return
+}
+
+type memoryTemplateRepository struct {
+	mu        sync.RWMutex
+	templates map[string]*ContainerTemplate // key is name
+}
+
+func NewMemoryTemplateRepository() TemplateRepository {
+	return &memoryTemplateRepository{templates: make(map[string]*ContainerTemplate)}
+}
+
+func (r *memoryTemplateRepository) Insert(ctx context.Context, template *ContainerTemplate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.templates[template.Name]; exist {
+		return ErrTemplateExist
+	}
+	r.templates[template.Name] = template.copy()
+	return nil
+}
+
+func (r *memoryTemplateRepository) Get(ctx context.Context, name string) (*ContainerTemplate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if t, exist := r.templates[name]; exist {
+		return t.copy(), nil
+	}
+	return nil, ErrTemplateNotFound
+}
+
+func (r *memoryTemplateRepository) List(ctx context.Context) ([]*ContainerTemplate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	templates := make([]*ContainerTemplate, 0, len(r.templates))
+	for _, t := range r.templates {
+		templates = append(templates, t.copy())
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func (r *memoryTemplateRepository) Update(ctx context.Context, template *ContainerTemplate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, exist := r.templates[template.Name]; !exist || t.Id != template.Id {
+		return ErrTemplateNotFound
+	}
+	r.templates[template.Name] = template.copy()
+	return nil
+}
+
+func (r *memoryTemplateRepository) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.templates[name]; !exist {
+		return ErrTemplateNotFound
+	}
+	delete(r.templates, name)
+	return nil
+}
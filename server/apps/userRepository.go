@@ -0,0 +1,253 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"iCloud/commons"
+	"sort"
+	"sync"
+)
+
+const (
+	MONGO_COLLECTION_USER      = "users"
+	MONGO_COLLECTION_API_TOKEN = "apiTokens"
+)
+
+var (
+	ErrUserNotFound     = errors.New("user dose not exist")
+	ErrUserExist        = errors.New("user exists already")
+	ErrApiTokenNotFound = errors.New("api token dose not exist")
+)
+
+// storage of users and their api tokens, it is mongoDB in service and in-process memory when mongoDB is unavailable or in test
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, username string) (*User, error)
+	List(ctx context.Context) ([]*User, error)
+	Update(ctx context.Context, user *User) error
+	Count(ctx context.Context) (int64, error)
+
+	InsertToken(ctx context.Context, token *ApiToken) error
+	GetTokenByHash(ctx context.Context, hash string) (*ApiToken, error)
+	FindTokens(ctx context.Context, username string) ([]*ApiToken, error)
+	DeleteToken(ctx context.Context, username, id string) error
+}
+
+type mongoUserRepository struct {
+	users  *mongo.Collection
+	tokens *mongo.Collection
+}
+
+func NewMongoUserRepository(m *commons.MONGO) UserRepository {
+	return &mongoUserRepository{
+		users:  m.Collection(MONGO_COLLECTION_USER),
+		tokens: m.Collection(MONGO_COLLECTION_API_TOKEN),
+	}
+}
+
+func (r *mongoUserRepository) Insert(ctx context.Context, user *User) (err error) {
+	if _, err = r.Get(ctx, user.Username); err == nil {
+		return ErrUserExist
+	} else if err != ErrUserNotFound {
+		return
+	}
+	_, err = r.users.InsertOne(ctx, user)
+	return
+}
+
+func (r *mongoUserRepository) Get(ctx context.Context, username string) (user *User, err error) {
+	user = new(User)
+	if err = r.users.FindOne(ctx, bson.M{"username": username}).Decode(user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrUserNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoUserRepository) List(ctx context.Context) (users []*User, err error) {
+	var (
+		cursor *mongo.Cursor
+	)
+
+	if cursor, err = r.users.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "username", Value: 1}})); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	users = make([]*User, 0)
+	err = cursor.All(ctx, &users)
+	return
+}
+
+func (r *mongoUserRepository) Update(ctx context.Context, user *User) (err error) {
+	var (
+		result *mongo.UpdateResult
+	)
+	if result, err = r.users.ReplaceOne(ctx, bson.M{"_id": user.Id}, user); err != nil {
+		return
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return
+}
+
+func (r *mongoUserRepository) Count(ctx context.Context) (int64, error) {
+	return r.users.CountDocuments(ctx, bson.M{})
+}
+
+func (r *mongoUserRepository) InsertToken(ctx context.Context, token *ApiToken) (err error) {
+	_, err = r.tokens.InsertOne(ctx, token)
+	return
+}
+
+func (r *mongoUserRepository) GetTokenByHash(ctx context.Context, hash string) (token *ApiToken, err error) {
+	token = new(ApiToken)
+	if err = r.tokens.FindOne(ctx, bson.M{"hash": hash}).Decode(token); err != nil {
+		if err == mongo.ErrNoDocuments {
+			err = ErrApiTokenNotFound
+		}
+		return nil, err
+	}
+	return
+}
+
+func (r *mongoUserRepository) FindTokens(ctx context.Context, username string) (tokens []*ApiToken, err error) {
+	var (
+		cursor *mongo.Cursor
+	)
+
+	if cursor, err = r.tokens.Find(ctx, bson.M{"username": username}, options.Find().SetSort(bson.D{{Key: "createTime", Value: -1}})); err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+
+	tokens = make([]*ApiToken, 0)
+	err = cursor.All(ctx, &tokens)
+	return
+}
+
+func (r *mongoUserRepository) DeleteToken(ctx context.Context, username, id string) (err error) {
+	var (
+		result *mongo.DeleteResult
+	)
+	if result, err = r.tokens.DeleteOne(ctx, bson.M{"_id": id, "username": username}); err != nil {
+		return
+	}
+	if result.DeletedCount == 0 {
+		return ErrApiTokenNotFound
+	}
+	return
+}
+
+type memoryUserRepository struct {
+	mu     sync.RWMutex
+	users  map[string]*User // key is username
+	tokens []*ApiToken
+}
+
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{users: make(map[string]*User), tokens: make([]*ApiToken, 0)}
+}
+
+func (r *memoryUserRepository) Insert(ctx context.Context, user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.users[user.Username]; exist {
+		return ErrUserExist
+	}
+	u := *user
+	r.users[user.Username] = &u
+	return nil
+}
+
+func (r *memoryUserRepository) Get(ctx context.Context, username string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if u, exist := r.users[username]; exist {
+		user := *u
+		return &user, nil
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *memoryUserRepository) List(ctx context.Context) ([]*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	users := make([]*User, 0, len(r.users))
+	for _, u := range r.users {
+		user := *u
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u, exist := r.users[user.Username]; !exist || u.Id != user.Id {
+		return ErrUserNotFound
+	}
+	u := *user
+	r.users[user.Username] = &u
+	return nil
+}
+
+func (r *memoryUserRepository) Count(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return int64(len(r.users)), nil
+}
+
+func (r *memoryUserRepository) InsertToken(ctx context.Context, token *ApiToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := *token
+	r.tokens = append(r.tokens, &t)
+	return nil
+}
+
+func (r *memoryUserRepository) GetTokenByHash(ctx context.Context, hash string) (*ApiToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.tokens {
+		if t.Hash == hash {
+			token := *t
+			return &token, nil
+		}
+	}
+	return nil, ErrApiTokenNotFound
+}
+
+func (r *memoryUserRepository) FindTokens(ctx context.Context, username string) ([]*ApiToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// newest first
+	tokens := make([]*ApiToken, 0)
+	for i := len(r.tokens) - 1; i >= 0; i-- {
+		if r.tokens[i].Username == username {
+			token := *r.tokens[i]
+			tokens = append(tokens, &token)
+		}
+	}
+	return tokens, nil
+}
+
+func (r *memoryUserRepository) DeleteToken(ctx context.Context, username, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, t := range r.tokens {
+		if t.Id == id && t.Username == username {
+			r.tokens = append(r.tokens[:i], r.tokens[i+1:]...)
+			return nil
+		}
+	}
+	return ErrApiTokenNotFound
+}
@@ -11,10 +11,13 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 var (
 	UploadTempDir = "D:/go_project/iCloud/uploadTemp"
+
+	ErrUploadNotFound = errors.New("upload task dose not exist")
 )
 
 // upload file by plugin webUploader on browser
@@ -26,6 +29,8 @@ func FileUpload(ctx *gin.Context) {
 		taskId       = ctx.PostForm("task_id")
 		chunkId      = ctx.PostForm("chunk")
 		blockNum     = ctx.PostForm("chunks")
+		project      = ctx.PostForm("project")
+		user         = callerUser(ctx)
 		n, sum       int
 		files        []*multipart.FileHeader
 		fileName     string
@@ -60,15 +65,42 @@ func FileUpload(ctx *gin.Context) {
 
 	fileName = files[0].Filename
 
-	fmt.Printf("%s--%s--%s--%s\n", fileName, taskId, blockNum, chunkId)
+	log.Logger.Debugf("%s, file %s of task %s, block %s, chunk %s", m, fileName, taskId, blockNum, chunkId)
+
+	if err = uploadNameCheck(taskId, fileName); err != nil {
+		httpStatus, rsp["ErrorCode"], rsp["Data"] = 308, 1, err.Error()
+		goto RESPONSE
+	}
+
+	// the first block creates the task, other blocks are written only by owner of the task
+	if err = uploadAuthorize(user, taskId, fileName, PERMISSION_WRITE); err != nil && (err != ErrUploadNotFound || !uploadFirstBlock(blockNum, chunkId)) {
+		httpStatus, rsp["ErrorCode"], rsp["Data"] = 308, 1, err.Error()
+		goto RESPONSE
+	}
+
+	if project != "" && !user.IsAdmin() && !user.InProject(project) {
+		httpStatus, rsp["ErrorCode"], rsp["Data"] = 308, 1, "permission denied, caller is not member of project "+project
+		goto RESPONSE
+	}
 
-	tempDir = path.Join(UploadTempDir, fileName+"--"+taskId)
-	if (blockNum == "" && chunkId == "") || chunkId == "0" {
+	if err = uploadQuotaCheck(ctx, project, files[0].Size); err != nil {
+		log.Logger.Infof("file %s of task %s is rejected, %v", fileName, taskId, err)
+		httpStatus, rsp["ErrorCode"], rsp["Data"] = 308, 1, err.Error()
+		goto RESPONSE
+	}
+
+	tempDir = uploadDir(taskId, fileName)
+	if uploadFirstBlock(blockNum, chunkId) {
 		if err = createDir(tempDir); err != nil {
 			log.Logger.Errorf("create dir %s error", tempDir)
 			httpStatus = 308
 			goto RESPONSE
 		}
+		if err = uploadOwnerWrite(tempDir, &uploadOwner{Owner: user.Username, Project: project}); err != nil {
+			log.Logger.Errorf("write owner of upload dir %s error: %v", tempDir, err)
+			httpStatus = 308
+			goto RESPONSE
+		}
 	}
 
 	if blockNum == "" && chunkId == "" {
@@ -116,6 +148,93 @@ RESPONSE:
 	ctx.JSON(httpStatus, rsp)
 }
 
+// merge blocks of file after webUploader finishes uploading, form data: task_id, name, chunks.
+// blocks are merged by FileUpload when the last block is received, so it is ok if the file is merged already
+func BlockFileMerge(ctx *gin.Context) {
+	var (
+		rsp           = make(gin.H)
+		m             = "apps.webUploader.BlockFileMerge()"
+		taskId        = ctx.PostForm("task_id")
+		fileName      = ctx.PostForm("name")
+		blockNum      = ctx.PostForm("chunks")
+		sum           int
+		finalFileName string
+		err           error
+	)
+
+	if err = uploadNameCheck(taskId, fileName); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+	if err = uploadAuthorize(callerUser(ctx), taskId, fileName, PERMISSION_WRITE); err != nil {
+		rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+		goto RESPONSE
+	}
+
+	finalFileName = uploadFilePath(taskId, fileName)
+	if _, err = os.Stat(finalFileName + "--0"); err == nil {
+		if sum, err = strconv.Atoi(blockNum); err != nil || sum <= 0 {
+			rsp["ErrorCode"], rsp["Data"] = 1, "param error, chunks is not a positive integer"
+			goto RESPONSE
+		}
+		if err = blockMerge(fileName, taskId, sum); err != nil {
+			log.Logger.Errorf("%s error, merge file %s error: %v", m, fileName, err)
+			rsp["ErrorCode"], rsp["Data"] = 1, err.Error()
+			goto RESPONSE
+		}
+	}
+
+	if _, err = os.Stat(finalFileName); err != nil {
+		log.Logger.Errorf("%s error, uploaded file %s dose not exist: %v", m, finalFileName, err)
+		rsp["ErrorCode"], rsp["Data"] = 1, "uploaded file dose not exist"
+		goto RESPONSE
+	}
+
+	rsp["ErrorCode"], rsp["Data"] = 0, gin.H{"taskId": taskId, "fileName": fileName}
+RESPONSE:
+	ctx.JSON(http.StatusOK, rsp)
+}
+
+// task id and file name are used as part of path, so they can not point to other dir
+func uploadNameCheck(taskId, fileName string) error {
+	for _, name := range []string{taskId, fileName} {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return errors.New("param error, task_id or name of file is invalid")
+		}
+	}
+	return nil
+}
+
+// file which is not chunked, or the first block of chunked file
+func uploadFirstBlock(blockNum, chunkId string) bool {
+	return (blockNum == "" && chunkId == "") || chunkId == "0"
+}
+
+// upload task is owned by user who uploads its first block, it can be written or used only by its owner, members of its project and admin
+func uploadAuthorize(user *User, taskId, fileName, permission string) error {
+	owner, err := uploadOwnerRead(uploadDir(taskId, fileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrUploadNotFound
+		}
+		log.Logger.Errorf("apps.webUploader.uploadAuthorize() error, read owner of upload task %s error: %v", taskId, err)
+		return errors.New("read owner of upload task error")
+	}
+	if !user.Allowed(owner.Owner, owner.Project, permission) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// dir of uploaded file, it contains the merged file only after upload is finished
+func uploadDir(taskId, fileName string) string {
+	return path.Join(UploadTempDir, fileName+"--"+taskId)
+}
+
+func uploadFilePath(taskId, fileName string) string {
+	return path.Join(uploadDir(taskId, fileName), fileName)
+}
+
 // sumBlock is number of block file
 // fileName is file name of upload, and it is the name of merged file
 // taskId is created by webUploader
@@ -193,7 +312,7 @@ func removeDir(dir string) (err error) {
 
 func storageFileTo(fileName string) (err error) {
 	// TODO storage file to distributed storage system
-	fmt.Printf("storage file[%s] to ... ....\n", fileName)
+	log.Logger.Debugf("storage file[%s] to ... ....", fileName)
 
 	// TODO remove temp dir
 	return
@@ -0,0 +1,148 @@
+package commons
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cron expression of 5 fields: minute hour day-of-month month day-of-week, e.g. "30 2 * * 1-5".
+// a field is "*", number, range "a-b", step "*/n" or "a-b/n", or list of them separated by ",".
+// day-of-week is 0-7 and both 0 and 7 are Sunday, if both day fields are not "*" a day matches any of them
+type Cron struct {
+	Expr                          string
+	minute, hour, dom, month, dow uint64 // bit i is set if value i matches
+	domAny, dowAny                bool
+}
+
+func ParseCron(expr string) (*Cron, error) {
+	var (
+		c      = &Cron{Expr: expr}
+		fields []string
+		err    error
+	)
+
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+	if fields = strings.Fields(expr); len(fields) != 5 {
+		return nil, errors.New("cron expression must have 5 fields: minute hour day-of-month month day-of-week")
+	}
+
+	if c.minute, err = cronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute of cron expression error: %v", err)
+	}
+	if c.hour, err = cronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour of cron expression error: %v", err)
+	}
+	if c.dom, err = cronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day-of-month of cron expression error: %v", err)
+	}
+	if c.month, err = cronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month of cron expression error: %v", err)
+	}
+	if c.dow, err = cronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day-of-week of cron expression error: %v", err)
+	}
+	// 7 is Sunday too
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+func cronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var (
+			start, end, step = min, max, 1
+			rangePart        = part
+		)
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.New("step of " + part + " is not positive integer")
+			}
+			rangePart = part[:i]
+		}
+
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New(part + " is not number or range")
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, errors.New(part + " is not number or range")
+			}
+		default:
+			if start, err = strconv.Atoi(rangePart); err != nil {
+				return 0, errors.New(part + " is not number or range")
+			}
+			// "a/n" is from a to max
+			if end = start; strings.Contains(part, "/") {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%s is out of range %d-%d", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *Cron) dayMatch(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// first time matched after t, zero time if no time is matched in 5 years, e.g. "0 0 30 2 *"
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatch(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// time skipped by daylight saving may be normalized to time before t, e.g. 2:00 is 1:00 in New York when it starts,
+		// so it goes minute by minute until the skipped time is passed
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
@@ -0,0 +1,104 @@
+package commons
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"30 2 * * 1-5", true},
+		{"*/15 0-6/2 1,15 * 7", true},
+		{"5/10 * * * *", true},
+		{"@daily", true},
+		{" @hourly ", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"@every", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if _, err := ParseCron(tt.expr); (err == nil) != tt.ok {
+				t.Errorf("ParseCron(%q) error = %v, want ok %v", tt.expr, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database is unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time // zero if no time matches
+	}{
+		{"next minute", "* * * * *", time.Date(2021, 1, 1, 10, 0, 30, 0, time.UTC), time.Date(2021, 1, 1, 10, 1, 0, 0, time.UTC)},
+		{"not the same minute", "0 10 * * *", time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC), time.Date(2021, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"step", "*/15 * * * *", time.Date(2021, 1, 1, 10, 16, 0, 0, time.UTC), time.Date(2021, 1, 1, 10, 30, 0, 0, time.UTC)},
+		{"end of year", "0 0 1 1 *", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"weekday", "30 2 * * 1-5", time.Date(2021, 1, 1, 3, 0, 0, 0, time.UTC), time.Date(2021, 1, 4, 2, 30, 0, 0, time.UTC)}, // Friday to Monday
+		{"7 is Sunday", "0 0 * * 7", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"day of month or day of week", "0 0 15 * 1", time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC)},
+		{"day of month or day of week, month day first", "0 0 8 * 1", time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"day of month and any day of week", "0 0 15 * *", time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"day of week and step of day of month", "0 0 */10 * 1", time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"time zone", "0 9 * * *", time.Date(2021, 1, 1, 10, 0, 0, 0, newYork), time.Date(2021, 1, 2, 9, 0, 0, 0, newYork)},
+		// 2:00 is 3:00 in New York on 2021-03-14, time in the gap is skipped on that day
+		{"dst start, hour in gap", "30 2 * * *", time.Date(2021, 3, 14, 0, 0, 0, 0, newYork), time.Date(2021, 3, 15, 2, 30, 0, 0, newYork)},
+		{"dst start, hour after gap", "0 3 * * *", time.Date(2021, 3, 14, 0, 0, 0, 0, newYork), time.Date(2021, 3, 14, 3, 0, 0, 0, newYork)},
+		{"dst start, every hour", "0 * * * *", time.Date(2021, 3, 14, 1, 30, 0, 0, newYork), time.Date(2021, 3, 14, 3, 0, 0, 0, newYork)},
+		// 2:00 is 1:00 in New York on 2021-11-07
+		{"dst end", "0 2 * * *", time.Date(2021, 11, 7, 0, 0, 0, 0, newYork), time.Date(2021, 11, 7, 2, 0, 0, 0, newYork)},
+		{"dst end, daily", "0 9 * * *", time.Date(2021, 11, 6, 10, 0, 0, 0, newYork), time.Date(2021, 11, 7, 9, 0, 0, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q) error: %v", tt.expr, err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextDstEndNotLoop(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database is unavailable: %v", err)
+	}
+
+	// every minute through the repeated hour, time only goes forward
+	c, _ := ParseCron("* * * * *")
+	from := time.Date(2021, 11, 7, 0, 30, 0, 0, newYork)
+	for i := 0; i < 180; i++ {
+		next := c.Next(from)
+		if next.Sub(from) != time.Minute {
+			t.Fatalf("Next(%v) = %v, want one minute later", from, next)
+		}
+		from = next
+	}
+}
@@ -0,0 +1,60 @@
+package commons
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// 32 bytes key of AES-256 derived from key in configuration
+func AesKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// encrypt by AES-GCM, result is base64 of nonce and cipher text
+func AesEncrypt(key, plain []byte) (string, error) {
+	var (
+		block cipher.Block
+		gcm   cipher.AEAD
+		nonce []byte
+		err   error
+	)
+
+	if block, err = aes.NewCipher(key); err != nil {
+		return "", err
+	}
+	if gcm, err = cipher.NewGCM(block); err != nil {
+		return "", err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func AesDecrypt(key []byte, encrypted string) (plain []byte, err error) {
+	var (
+		block   cipher.Block
+		gcm     cipher.AEAD
+		content []byte
+	)
+
+	if content, err = base64.StdEncoding.DecodeString(encrypted); err != nil {
+		return
+	}
+	if block, err = aes.NewCipher(key); err != nil {
+		return
+	}
+	if gcm, err = cipher.NewGCM(block); err != nil {
+		return
+	}
+	if len(content) < gcm.NonceSize() {
+		return nil, errors.New("cipher text is too short")
+	}
+	return gcm.Open(nil, content[:gcm.NonceSize()], content[gcm.NonceSize():], nil)
+}
@@ -12,9 +12,27 @@ const (
 	ETCD_KEY_PRE                        = "/iCloud/host_info/"
 	ETCD_TIMEOUT                        = 100
 	CONTAINER_ENTRY_POINT_SCRIPT        = "start.sh"
+	HOST_HEARTBEAT_TIMEOUT              = 10 // seconds, host is offline if no heartbeat in it
+	HOST_MIN_FREE_DISK                  = 5  // GB, host can not hold new container if free disk is less than it
+	PLACEMENT_RESERVE_TIMEOUT           = 30 // seconds, resource of placed container is reserved on host until its usage is in heartbeat
+	MONGO_DB                            = "iCloud"
+	MONGO_TIMEOUT                       = time.Second * 2
+	DOCKER_PING_INTERVAL                = time.Second * 10
+	DOCKER_PING_TIMEOUT                 = time.Second * 3
+	DOCKER_PING_MAX_FAILURES            = 3                // client is evicted from pool after continuous failures of ping
+	SUPERVISOR_SNAPSHOT_INTERVAL        = time.Second * 30 // containers with reschedule are listed in this interval
+	JOB_WAIT_RETRY_INTERVAL             = time.Second * 10
+	JOB_WAIT_MAX_RETRIES                = 30    // job is failed if waiting its container fails continuously
+	JOB_LOG_TAIL                        = "100" // lines of log kept after job exits
+	JOB_DEFAULT_MAX_RETRIES             = 3     // job is failed if it fails to start more than it
+	JOB_DISPATCH_INTERVAL               = time.Second * 5
+	JOB_RETRY_BACKOFF                   = time.Second * 10 // doubled on every failed start
+	JOB_RETRY_MAX_BACKOFF               = time.Minute * 10
+	SCHEDULE_CHECK_INTERVAL             = time.Second * 10 // schedules which are due are run in this interval
 )
 
 var (
 	DockerApiCli *client.Client
 	EtcdCli      *clientv3.Client
+	Mongo        = new(MONGO)
 )
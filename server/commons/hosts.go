@@ -13,5 +13,6 @@ type Host struct {
 	FreeDisk  int    `json:"freeDisk"`
 	Heartbeat int64  `json:"heartbeat"`		// current  timestamp
 	GrpcPort  string `json:"grpcPort"`
+	Labels    map[string]string `json:"labels"`
 }
 
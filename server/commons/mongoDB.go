@@ -13,13 +13,28 @@ type MONGO struct {
 }
 
 func (m *MONGO) MongoInit() (err error) {
-	var (
-		ctx context.Context
-	)
 	uri := "mongodb://" + conf.Iconf.Mongo
-	ctx, _ = context.WithTimeout(context.TODO(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
 	if m.cli, err = mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMaxPoolSize(5).SetMinPoolSize(2)); err != nil {
 		return
 	}
+	// mongo.Connect dose not connect to server, ping to make sure mongoDB is available
+	if err = m.cli.Ping(ctx, nil); err != nil {
+		return
+	}
 	return
-}
\ No newline at end of file
+}
+
+func (m *MONGO) Collection(name string) *mongo.Collection {
+	return m.cli.Database(MONGO_DB).Collection(name)
+}
+
+func (m *MONGO) Close() {
+	if m.cli == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+	m.cli.Disconnect(ctx)
+}
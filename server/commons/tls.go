@@ -0,0 +1,70 @@
+package commons
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"iCloud/conf"
+	"io/ioutil"
+	"strings"
+)
+
+// tls config of client, it verifies peer by CA and sends its own certificate to be verified by peer.
+// serverName is the name which certificate of peer must contain, ServerName in configuration overrides it
+func TlsClientConfig(tlsConf *conf.TlsConf, serverName string) (config *tls.Config, err error) {
+	var (
+		caPem   []byte
+		certs   = x509.NewCertPool()
+		keyPair tls.Certificate
+	)
+
+	if caPem, err = ioutil.ReadFile(tlsConf.CA); err != nil {
+		return nil, fmt.Errorf("read CA file %s error: %v", tlsConf.CA, err)
+	}
+	if !certs.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("no certificate in CA file %s", tlsConf.CA)
+	}
+
+	if keyPair, err = tls.LoadX509KeyPair(tlsConf.Cert, tlsConf.Key); err != nil {
+		return nil, fmt.Errorf("load certificate %s and key %s error: %v", tlsConf.Cert, tlsConf.Key, err)
+	}
+
+	if tlsConf.ServerName != "" {
+		serverName = tlsConf.ServerName
+	}
+
+	return &tls.Config{
+		RootCAs:      certs,
+		Certificates: []tls.Certificate{keyPair},
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// describe error of tls handshake, ok is false if err is not caused by certificate
+func TlsVerifyError(err error) (reason string, ok bool) {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+	)
+
+	switch {
+	case err == nil:
+		return "", false
+	case errors.As(err, &unknownAuthority):
+		return "certificate of peer is not signed by configured CA", true
+	case errors.As(err, &hostname):
+		return fmt.Sprintf("certificate of peer is not valid for %s, set serverName if peer uses another name", hostname.Host), true
+	case errors.As(err, &invalid):
+		return "certificate of peer is invalid: " + invalid.Error(), true
+	case strings.Contains(err.Error(), "bad certificate") || strings.Contains(err.Error(), "certificate required"):
+		return "certificate of self is rejected by peer, check cert and key in configuration", true
+	case strings.Contains(err.Error(), "first record does not look like a TLS handshake"):
+		return "peer dose not use tls, remove tls of it in configuration", true
+	case strings.Contains(err.Error(), "malformed HTTP response"):
+		return "peer uses tls, but tls of it is not configured", true
+	}
+	return "", false
+}
@@ -13,11 +13,16 @@ var (
 )
 
 type iCloudConf struct {
-	Ip          string        `xml:"ip"`          // service listen on
-	Port        int           `xml:"port"`        // service listen on
-	Etcd        []string      `xml:"etcd"`        // etcd ip:port
-	Mongo       string        `xml:"mongo"`       // mondoDB
-	Log         iCloudLogConf `xml:"log"`
+	Ip          string           `xml:"ip"`          // service listen on
+	Port        int              `xml:"port"`        // service listen on
+	Etcd        []string         `xml:"etcd"`        // etcd ip:port
+	Mongo       string           `xml:"mongo"`       // mondoDB
+	MemoryStore bool             `xml:"memoryStore"` // keep data in memory instead of mongoDB, for test only
+	Log         iCloudLogConf    `xml:"log"`
+	Docker      iCloudDockerConf `xml:"docker"`
+	Grpc        iCloudGrpcConf   `xml:"grpc"`
+	Auth        iCloudAuthConf   `xml:"auth"`
+	Quota       iCloudQuotaConf  `xml:"quota"`
 }
 
 type iCloudLogConf struct {
@@ -30,6 +35,68 @@ type iCloudLogConf struct {
 	Level      string `xml:"level"`      // log level
 }
 
+// connection to docker remote api of hosts
+type iCloudDockerConf struct {
+	Tls   *TlsConf         `xml:"tls"`  // used by all hosts, docker api is connected in plaintext if it is not set
+	Hosts []dockerHostConf `xml:"host"` // tls of host overrides the global one
+}
+
+type dockerHostConf struct {
+	Ip  string   `xml:"ip,attr"`
+	Tls *TlsConf `xml:"tls"`
+}
+
+// authentication of api
+type iCloudAuthConf struct {
+	JwtSecret    string          `xml:"jwtSecret"`    // key to sign login token, random key is used if it is empty
+	TokenExpire  int             `xml:"tokenExpire"`  // hours of login token
+	Admin        iCloudAdminConf `xml:"admin"`        // user created when there is no user
+	SecretKey    string          `xml:"secretKey"`    // key to encrypt secrets stored in mongoDB, secrets can not be used if it is empty
+	CookieSecure bool            `xml:"cookieSecure"` // cookie of login token is sent only in https
+}
+
+type iCloudAdminConf struct {
+	Username string `xml:"username"`
+	Password string `xml:"password"` // random password is printed when admin is created if it is empty
+}
+
+// default quotas, they are used if quota of user or project is not set by admin
+type iCloudQuotaConf struct {
+	User    QuotaLimit `xml:"user"`
+	Project QuotaLimit `xml:"project"`
+}
+
+// 0 is unlimited
+type QuotaLimit struct {
+	MaxCpu        float64 `xml:"maxCpu"`        // cores
+	MaxMem        float64 `xml:"maxMem"`        // GB
+	MaxContainers int     `xml:"maxContainers"` // containers which are not exited
+	MaxUploadDisk float64 `xml:"maxUploadDisk"` // GB of uploaded files
+}
+
+// connection to grpc server of clients on hosts
+type iCloudGrpcConf struct {
+	Tls *TlsConf `xml:"tls"` // mutual tls, grpc is called without tls if it is not set
+}
+
+// certificates in PEM
+type TlsConf struct {
+	CA         string `xml:"ca"`         // CA to verify certificate of peer
+	Cert       string `xml:"cert"`       // certificate of self
+	Key        string `xml:"key"`        // private key of self
+	ServerName string `xml:"serverName"` // name in certificate of peer, ip of peer is used if it is empty
+}
+
+// tls of docker api on host, nil if docker api is in plaintext
+func (conf *iCloudDockerConf) TlsOf(ip string) *TlsConf {
+	for _, host := range conf.Hosts {
+		if host.Ip == ip && host.Tls != nil {
+			return host.Tls
+		}
+	}
+	return conf.Tls
+}
+
 func (conf *iCloudConf) newConf() (err error) {
 	var (
 		confContect []byte
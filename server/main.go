@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/coreos/etcd/clientv3"
 	"github.com/gin-gonic/gin"
@@ -23,7 +24,6 @@ func init() {
 		fmt.Println("load configuration error:", err)
 		os.Exit(1)
 	}
-	fmt.Println(conf.Iconf)
 
 	log.InitLogger()
 
@@ -34,14 +34,19 @@ func init() {
 		log.Logger.Error("etcd init error: %v", err)
 	}
 
-	apps.DockerApiCliMapInit()
+	if err = apps.RepositoryInit(); err != nil {
+		fmt.Println("connect to mongoDB error, set memoryStore to run without it:", err)
+		os.Exit(1)
+	}
+	apps.AuthInit()
 }
 
 func main() {
 	log.Logger.Info("iCloud server started")
 
 	defer func() {
-		apps.DockerApiCliPoolClose()
+		apps.DockerClientPool.Close()
+		commons.Mongo.Close()
 		log.Logger.Info("iCloud server closed")
 		log.Logger.Sync()
 	}()
@@ -57,9 +62,18 @@ func main() {
 
 	ICloudRouter(ginEngine)
 
+	watchCtx, watchCancel := context.WithCancel(context.TODO())
+	defer watchCancel()
+	go apps.HostWatch(watchCtx)
+	go apps.DockerClientPool.Watch(watchCtx)
+	go apps.ContainerSupervisor.Watch(watchCtx)
+	go apps.JobRecover(watchCtx)
+	go apps.JobDispatcher.Watch(watchCtx)
+	go apps.ScheduleWatch(watchCtx)
+
 	go ginEngine.Run(conf.Iconf.Ip + ":" + strconv.Itoa(conf.Iconf.Port))
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	s := <-c
 	fmt.Println(s)
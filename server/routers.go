@@ -6,28 +6,138 @@ import (
 )
 
 func ICloudRouter(r *gin.Engine) {
-	HostRouters := r.Group("/iCloudApi/hosts")
+	// login is the only route which is open
+	r.POST("/iCloudApi/auth/login", apps.UserLogin)
+	AuthRouters := r.Group("/iCloudApi/auth", apps.AuthRequired())
+	{
+		AuthRouters.POST("/logout", apps.UserLogout)
+		AuthRouters.GET("/me", apps.UserMe)
+		AuthRouters.PUT("/password", apps.UserPasswordChange)
+		AuthRouters.POST("/tokens", apps.ApiTokenCreate)
+		AuthRouters.GET("/tokens", apps.ApiTokenList)
+		AuthRouters.DELETE("/tokens/:id", apps.ApiTokenDelete)
+	}
+
+	UserRouters := r.Group("/iCloudApi/users", apps.AuthRequired())
+	{
+		UserRouters.POST("/create", apps.RoleRequired(apps.ROLE_ADMIN), apps.UserCreate)
+		UserRouters.GET("/list", apps.RoleRequired(apps.ROLE_ADMIN), apps.UserList)
+		UserRouters.PUT("/role/:username", apps.RoleRequired(apps.ROLE_ADMIN), apps.UserRoleUpdate)
+	}
+
+	QuotaRouters := r.Group("/iCloudApi/quotas", apps.AuthRequired())
+	{
+		QuotaRouters.GET("/usage", apps.QuotaUsageGet)
+		QuotaRouters.GET("/list", apps.RoleRequired(apps.ROLE_ADMIN), apps.QuotaList)
+		QuotaRouters.PUT("/:scope/:name", apps.RoleRequired(apps.ROLE_ADMIN), apps.QuotaSet)
+		QuotaRouters.DELETE("/:scope/:name", apps.RoleRequired(apps.ROLE_ADMIN), apps.QuotaDelete)
+	}
+
+	HostRouters := r.Group("/iCloudApi/hosts", apps.AuthRequired())
 	{
 		HostRouters.GET("/list", apps.HostList)
+		HostRouters.GET("/detail/:ip", apps.HostDetail)
+		HostRouters.GET("/dockerClients", apps.RoleRequired(apps.ROLE_ADMIN), apps.DockerClientPoolStatus)
+		HostRouters.GET("/supervisor", apps.RoleRequired(apps.ROLE_ADMIN), apps.ContainerSupervisorStatus)
 	}
 
-	DockerConfigRouters := r.Group("/iCloudApi/containers")
+	DockerConfigRouters := r.Group("/iCloudApi/containers", apps.AuthRequired())
 	{
 		DockerConfigRouters.GET("/list", apps.ContainerList)
 		DockerConfigRouters.GET("/lsImage", apps.ImageList)
 		DockerConfigRouters.POST("/createAndRun/:ip/:port/:rPort", apps.ContainerCreate)
+		DockerConfigRouters.POST("/schedule", apps.ContainerSchedule)
 		DockerConfigRouters.PUT("/start/:id/:ip/:port/:rPort", apps.ContainerStart)
 		DockerConfigRouters.PUT("/stop/:id/:ip/:port/:rPort", apps.ContainerStop)
 		DockerConfigRouters.DELETE("/remove/:id/:ip/:port/:rPort", apps.ContainerRemove)
 		DockerConfigRouters.GET("/detail/:id/:ip/:port/:rPort", apps.ContainerDetail)
+		DockerConfigRouters.POST("/clone/:id/:ip/:port", apps.ContainerClone)
+		DockerConfigRouters.GET("/stats/:id/:ip/:port", apps.ContainerStats)
+		DockerConfigRouters.GET("/hostStats/:ip/:port", apps.HostContainerStats)
+		DockerConfigRouters.GET("/exec/:id/:ip", apps.ContainerExec)
+		DockerConfigRouters.GET("/execSessions", apps.ContainerExecSessions)
+	}
+
+	ImageRouters := r.Group("/iCloudApi/images", apps.AuthRequired())
+	{
+		ImageRouters.POST("/pull/:ip/:port", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.ImagePull)
+		ImageRouters.GET("/inspect/:ip/:port", apps.ImageInspect)
+		ImageRouters.GET("/history/:ip/:port", apps.ImageHistory)
+		ImageRouters.PUT("/tag/:ip/:port", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.ImageTag)
+		ImageRouters.DELETE("/remove/:ip/:port", apps.RoleRequired(apps.ROLE_ADMIN), apps.ImageRemove)
+		ImageRouters.POST("/prune/:ip/:port", apps.RoleRequired(apps.ROLE_ADMIN), apps.ImagePrune)
+		ImageRouters.POST("/build/:ip/:port", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.ImageBuild)
+	}
+
+	NetworkRouters := r.Group("/iCloudApi/networks", apps.AuthRequired())
+	{
+		NetworkRouters.GET("/list/:ip/:port", apps.NetworkList)
+		NetworkRouters.POST("/create/:ip/:port", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.NetworkCreate)
+		NetworkRouters.DELETE("/remove/:ip/:port", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.NetworkRemove)
+	}
+
+	TemplateRouters := r.Group("/iCloudApi/templates", apps.AuthRequired())
+	{
+		TemplateRouters.POST("/create", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.TemplateCreate)
+		TemplateRouters.GET("/list", apps.TemplateList)
+		TemplateRouters.GET("/detail/:name", apps.TemplateDetail)
+		TemplateRouters.PUT("/update/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.TemplateUpdate)
+		TemplateRouters.DELETE("/remove/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.TemplateDelete)
+		TemplateRouters.POST("/createAndRun/:name/:ip/:port", apps.ContainerCreateFromTemplate)
+	}
+
+	SecretRouters := r.Group("/iCloudApi/secrets", apps.AuthRequired())
+	{
+		SecretRouters.POST("/create", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.SecretCreate)
+		SecretRouters.GET("/list", apps.SecretList)
+		SecretRouters.PUT("/update/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.SecretUpdate)
+		SecretRouters.DELETE("/remove/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.SecretDelete)
+	}
+
+	DeploymentRouters := r.Group("/iCloudApi/deployments", apps.AuthRequired())
+	{
+		DeploymentRouters.GET("/list", apps.DeploymentList)
+		DeploymentRouters.GET("/detail/:id", apps.DeploymentDetail)
+		DeploymentRouters.POST("/rerun/:id", apps.DeploymentRerun)
+	}
+
+	JobRouters := r.Group("/iCloudApi/jobs", apps.AuthRequired())
+	{
+		JobRouters.POST("/submit", apps.JobSubmit)
+		JobRouters.GET("/list", apps.JobList)
+		JobRouters.GET("/detail/:id", apps.JobDetail)
+		JobRouters.GET("/queue", apps.JobQueueList)
+		JobRouters.PUT("/cancel/:id", apps.JobCancel)
+	}
+
+	ScheduleRouters := r.Group("/iCloudApi/schedules", apps.AuthRequired())
+	{
+		ScheduleRouters.POST("/create", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.ScheduleCreate)
+		ScheduleRouters.GET("/list", apps.ScheduleList)
+		ScheduleRouters.GET("/detail/:name", apps.ScheduleDetail)
+		ScheduleRouters.GET("/history/:name", apps.ScheduleHistory)
+		ScheduleRouters.PUT("/update/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.ScheduleUpdate)
+		ScheduleRouters.DELETE("/remove/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.ScheduleDelete)
+		ScheduleRouters.POST("/run/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.ScheduleRun)
+	}
+
+	StackRouters := r.Group("/iCloudApi/stacks", apps.AuthRequired())
+	{
+		StackRouters.POST("/deploy", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.StackDeploy)
+		StackRouters.GET("/list", apps.StackList)
+		StackRouters.GET("/detail/:name", apps.StackDetail)
+		StackRouters.PUT("/start/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.StackStart)
+		StackRouters.PUT("/stop/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.StackStop)
+		StackRouters.DELETE("/remove/:name", apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER), apps.StackRemove)
 	}
 
-	DockerLogRouters := r.Group("/iCloudApi/logs")
+	DockerLogRouters := r.Group("/iCloudApi/logs", apps.AuthRequired())
 	{
 		DockerLogRouters.POST("/:id/:ip/:port", apps.ContainerLogs)
+		DockerLogRouters.GET("/stream/:id/:ip/:port", apps.ContainerLogStream)
 	}
 
-	FileUpLoadRouter := r.Group("/iCloudApi/file/upload")
+	FileUpLoadRouter := r.Group("/iCloudApi/file/upload", apps.AuthRequired(), apps.RoleRequired(apps.ROLE_ADMIN, apps.ROLE_MEMBER))
 	{
 		FileUpLoadRouter.POST("/webUploader", apps.FileUpload)
 		FileUpLoadRouter.POST("/webUploader/merge", apps.BlockFileMerge)
@@ -2,20 +2,46 @@ package rpcServer
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"github.com/docker/docker/api/types/strslice"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"iCloud/commons"
+	"iCloud/conf"
 	"iCloud/log"
 )
 
+// mutual tls is used if it is set in configuration
+func dialOption(ip string) (opt grpc.DialOption, err error) {
+	var (
+		tlsConfig *tls.Config
+	)
+
+	if conf.Iconf.Grpc.Tls == nil {
+		return grpc.WithInsecure(), nil
+	}
+	if tlsConfig, err = commons.TlsClientConfig(conf.Iconf.Grpc.Tls, ip); err != nil {
+		return
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), nil
+}
+
 func CreateEntryPointScript(ip string, port string, pwd string, cmds strslice.StrSlice) (err error) {
 	var (
 		conn *grpc.ClientConn
 		clientRpc = ip + ":" + port
 		m = "rpcServer.entryPointScriptCreate()"
 		rsp = new(CreateScriptResponse)
+		dialOpt grpc.DialOption
 	)
 
-	if conn, err = grpc.Dial(clientRpc, grpc.WithInsecure()); err != nil {
+	if dialOpt, err = dialOption(ip); err != nil {
+		log.Logger.Errorf("%s error, load tls of grpc error: %v", m, err)
+		return
+	}
+
+	if conn, err = grpc.Dial(clientRpc, dialOpt); err != nil {
 		log.Logger.Errorf("%s error, connect to client %s error: %v", m, clientRpc, err)
 		return
 	}
@@ -26,10 +52,14 @@ func CreateEntryPointScript(ip string, port string, pwd string, cmds strslice.St
 		Pwd:pwd,
 		Cmd:cmds,
 	}); err != nil {
+		// call is rejected by client if certificate of server is not allowed
 		log.Logger.Errorf("%s error, call client %s to create entry point script by grpc error: %v", m, clientRpc, err)
-		log.Logger.Errorf("%s error: %v", m, rsp.ErrMessage)
 		return
 	}
+	if rsp.ErrMessage != "" {
+		log.Logger.Errorf("%s error, client %s create entry point script error: %s", m, clientRpc, rsp.ErrMessage)
+		return errors.New(rsp.ErrMessage)
+	}
 
 	return nil
 }
@@ -0,0 +1,20 @@
+// all api of iCloud require login, token is kept by browser in cookie set by /iCloudApi/auth/login.
+// page is redirected to login page when request is not authenticated, and back to page after login
+(function($) {
+	function loginRedirect() {
+		window.location.href = "login.html?redirect=" + encodeURIComponent(window.location.pathname + window.location.search)
+	}
+
+	$(document).ajaxError(function(event, xhr) {
+		if (xhr.status == 401) {
+			loginRedirect()
+		}
+	})
+
+	// login is checked when page is loaded, EventSource and uploader of pages do not show status of response
+	$.ajax({
+		url: "/iCloudApi/auth/me",
+		type: "get",
+		dataType: "json"
+	})
+})(jQuery);